@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// applyConfigFile loads settings from a YAML or JSON file whose keys are flag names
+// (e.g. `concurrency: 5`, `dir: postcodes`) and applies them to every flag not given
+// explicitly on the command line. Precedence is therefore flags > config file > defaults.
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %v", err)
+	}
+
+	// JSON is valid YAML, so one decoder handles both formats
+	var settings map[string]any
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("error parsing config file: %v", err)
+	}
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	// Apply in a stable order so errors are reported deterministically
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == "config" {
+			return fmt.Errorf("config file cannot set config")
+		}
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown setting %q in config file", name)
+		}
+		if explicit[name] {
+			continue
+		}
+		// A list sets a repeatable flag once per entry
+		values, ok := settings[name].([]any)
+		if !ok {
+			values = []any{settings[name]}
+		}
+		for _, value := range values {
+			if err := fs.Set(name, fmt.Sprint(value)); err != nil {
+				return fmt.Errorf("invalid value for %q in config file: %v", name, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	_ "expvar" // Registers /debug/vars, where main publishes the run's counters
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	_ "net/http/pprof" // Registers /debug/pprof/
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// startDebugServer serves pprof profiles and expvar counters on addr until the process
+// exits. An address without a host is bound to localhost, keeping the endpoint private.
+func startDebugServer(addr string) (*http.Server, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid debug address %q: %v", addr, err)
+	}
+	if host == "" {
+		host = "localhost"
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, fmt.Errorf("error starting debug server: %v", err)
+	}
+
+	server := &http.Server{Handler: http.DefaultServeMux}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			slog.Error("Debug server stopped", "err", err)
+		}
+	}()
+
+	slog.Info("Serving debug endpoints", "addr", listener.Addr().String(), "pprof", "/debug/pprof/", "expvar", "/debug/vars")
+	return server, nil
+}
+
+// startMetricsServer serves Prometheus metrics at /metrics on addr until the process exits
+func startMetricsServer(addr string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error starting metrics server: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			slog.Error("Metrics server stopped", "err", err)
+		}
+	}()
+
+	slog.Info("Serving Prometheus metrics", "addr", listener.Addr().String(), "path", "/metrics")
+	return server, nil
+}
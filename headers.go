@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// headerFlag collects repeated -header "Name: value" flags into the headers sent with each
+// lookup. An empty value removes a default header.
+type headerFlag struct {
+	headers http.Header
+}
+
+func (h *headerFlag) String() string {
+	if h == nil || h.headers == nil {
+		return ""
+	}
+	var pairs []string
+	for name, values := range h.headers {
+		pairs = append(pairs, name+": "+strings.Join(values, ", "))
+	}
+	return strings.Join(pairs, "; ")
+}
+
+func (h *headerFlag) Set(value string) error {
+	name, val, ok := strings.Cut(value, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return fmt.Errorf("invalid header %q: want \"Name: value\"", value)
+	}
+
+	if h.headers == nil {
+		h.headers = make(http.Header)
+	}
+	h.headers[http.CanonicalHeaderKey(name)] = []string{strings.TrimSpace(val)}
+	return nil
+}
+
+// apply sets the collected headers on headers, where the fetcher takes an empty value to
+// mean the header is removed, even one it sets itself
+func (h *headerFlag) apply(headers http.Header) {
+	for name, values := range h.headers {
+		headers[name] = values
+	}
+}
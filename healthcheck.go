@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/MaxWCode/TappedIN/supplier"
+)
+
+// defaultHealthcheckPostcode is a postcode known to have a supplier (Thames Water)
+const defaultHealthcheckPostcode = "SW1A 1AA"
+
+// runHealthcheck looks up a single known-good postcode, checking the endpoint, form token,
+// and parser all still work before a big run
+func runHealthcheck(ctx context.Context, fetcher *supplier.Fetcher, postcode string) error {
+	result, err := fetcher.Lookup(ctx, postcode)
+	if err != nil {
+		return fmt.Errorf("healthcheck lookup failed: %v (status %s, HTTP status %d, attempts %d)",
+			err, result.Status, result.HTTPStatus, result.Attempts)
+	}
+	if result.Status != supplier.StatusFound || result.Supplier == "" {
+		return fmt.Errorf("healthcheck found no supplier for %s: the site's markup may have changed", result.Postcode)
+	}
+
+	slog.Info("Healthcheck passed", "postcode", result.Postcode, "supplier", result.Supplier, "attempts", result.Attempts)
+	return nil
+}
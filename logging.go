@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// newHandler builds a structured log handler writing to w at the given level ("debug",
+// "info", "warn", or "error") in the given format ("text" or "json")
+func newHandler(w io.Writer, level, format string) (slog.Handler, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: must be debug, info, warn, or error", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q: must be text or json", format)
+	}
+}
+
+// openLogFile opens filename for logging, appending to it unless truncate is set
+func openLogFile(filename string, truncate bool) (*os.File, error) {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if truncate {
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(filename, flags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening log file: %v", err)
+	}
+	return file, nil
+}
+
+// teeHandler sends each record to every handler enabled for its level, so the console and
+// a log file can each log at their own level
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithGroup(name)
+	}
+	return handlers
+}
+
+// beforeFatalExit, when set, is called with the error message just before fatal exits
+var beforeFatalExit func(msg string)
+
+// fatal logs msg at error level and exits with a non-zero status
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	if beforeFatalExit != nil {
+		parts := []string{msg}
+		for i := 0; i+1 < len(args); i += 2 {
+			parts = append(parts, fmt.Sprintf("%v=%v", args[i], args[i+1]))
+		}
+		beforeFatalExit(strings.Join(parts, " "))
+	}
+	os.Exit(1)
+}
@@ -1,428 +1,454 @@
 package main
 
 import (
-	"encoding/csv"
-	"encoding/json"
+	"context"
+	"expvar"
+	"flag"
 	"fmt"
 	"io"
-	"log"
-	"net/http"
-	"net/url"
+	"log/slog"
 	"os"
-	"path/filepath"
-	"regexp"
-	"sort"
+	"os/signal"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
-)
-
-// PostcodeResult holds the result for each postcode lookup
-type PostcodeResult struct {
-	Postcode string `json:"postcode"`
-	Supplier string `json:"supplier"`
-	Phone    string `json:"phone"`
-	Link     string `json:"link"`
-}
 
-// AjaxResponse represents the structure of the JSON response
-type AjaxResponse struct {
-	Data string `json:"data"`
-}
-
-// Progress tracks the current state of processing
-type Progress struct {
-	LastFile     string `json:"last_file"`     // Last CSV file processed
-	LastPostcode string `json:"last_postcode"` // Last postcode processed
-	Completed    bool   `json:"completed"`     // Whether all processing is complete
-}
+	"github.com/MaxWCode/TappedIN/supplier"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
 
 const (
-	maxRetries    = 3
-	maxGoroutines = 3
-	postcodeDir   = "ALLCODECSV"
-	progressFile  = "progress.json"
-	resultsFile   = "water_suppliers_results.json"
+	defaultPostcodeDir = "ALLCODECSV"
+
+	// shutdownGracePeriod is how long in-flight requests get to finish after an interrupt
+	shutdownGracePeriod = 10 * time.Second
 )
 
-// loadProgress loads the current progress from the progress file
-func loadProgress() (*Progress, error) {
-	data, err := os.ReadFile(progressFile)
-	if err != nil {
-		if os.IsNotExist(err) {
-			// If file doesn't exist, return new progress
-			return &Progress{}, nil
+func main() {
+	// Subcommands work on saved results rather than looking postcodes up
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "report":
+			if err := runReport(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		case "merge":
+			if err := runMerge(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
 		}
-		return nil, fmt.Errorf("error reading progress file: %v", err)
-	}
-
-	var progress Progress
-	if err := json.Unmarshal(data, &progress); err != nil {
-		return nil, fmt.Errorf("error parsing progress file: %v", err)
 	}
 
-	return &progress, nil
-}
-
-// saveProgress saves the current progress to the progress file
-func saveProgress(progress *Progress) error {
-	data, err := json.MarshalIndent(progress, "", "  ")
-	if err != nil {
-		return fmt.Errorf("error marshalling progress: %v", err)
+	format := flag.String("format", "json", "output format: json, csv, both, or ndjson")
+	output := flag.String("output", supplier.ResultsFile, "JSON results file to load and save; csv and ndjson results go beside it, named after it")
+	compressOutput := flag.Bool("compress-output", false, "gzip the JSON results file, adding .gz to -output")
+	storeType := flag.String("store", "json", "result storage backend: json or sqlite")
+	database := flag.String("database", supplier.DatabaseFile, "SQLite database used with -store sqlite")
+	progressFile := flag.String("progress-file", supplier.ProgressFile, "file recording progress through the postcode files, so an interrupted run resumes")
+	failedFile := flag.String("failed-file", supplier.FailedPostcodesFile, "file recording postcodes whose lookups failed")
+	deadLetterFile := flag.String("dead-letter-file", supplier.DeadLetterFile, "file recording postcodes that failed again in a retry pass")
+	manifestFile := flag.String("manifest-file", supplier.ManifestFile, "file recording each postcode file processed and its checksum")
+	concurrency := flag.Int("concurrency", supplier.DefaultConcurrency, "number of postcodes to look up concurrently")
+	adaptive := flag.Bool("adaptive", false, "adjust concurrency to response latency and errors, starting at -concurrency")
+	maxConcurrency := flag.Int("max-concurrency", supplier.DefaultMaxConcurrency, "upper bound on concurrency with -adaptive")
+	postcodeDir := flag.String("dir", defaultPostcodeDir, "directory containing the postcode CSV files, or - to read postcodes from stdin")
+	postcodeFile := flag.String("file", "", "process only this postcode CSV file instead of the files in -dir")
+	onlyFile := flag.String("only-file", "", "file of newline-delimited postcodes to look up, skipping all others")
+	noResume := flag.Bool("no-resume", false, "start a clean run, ignoring -progress-file and earlier failures and overwriting the existing results")
+	force := flag.Bool("force", false, "walk every file again, even those completed and unchanged since -manifest-file recorded them (postcodes with results are still skipped)")
+	skipFile := flag.String("skip-file", "", "file of newline-delimited postcodes never to look up")
+	startFile := flag.String("start-file", "", "first file to process, by base name, in the sorted file list")
+	endFile := flag.String("end-file", "", "last file to process, by base name, in the sorted file list")
+	postcodeColumn := flag.Int("postcode-column", 0, "zero-based index of the CSV column holding the postcode")
+	delimiter := flag.String("delimiter", ",", `field delimiter of the CSV files: a single character, "tab", or "auto" to detect it from each file's first line`)
+	hasHeader := flag.Bool("has-header", false, "skip the first row of each CSV file (otherwise skipped only when it isn't a postcode)")
+	retries := flag.Int("retries", supplier.DefaultRetries, "attempts made at each postcode before giving up, including the first")
+	retryDelay := flag.Duration("retry-delay", supplier.DefaultRetryDelay, "base delay before retrying a failed lookup, doubled each attempt")
+	maxRetryDelay := flag.Duration("max-retry-delay", supplier.DefaultMaxRetryDelay, "upper bound on the delay between retries, including waits asked for by Retry-After")
+	breakerFailures := flag.Int("breaker-failures", supplier.DefaultBreakerFailures, "consecutive failed requests that pause all lookups for -breaker-cooldown (0 to disable)")
+	breakerCooldown := flag.Duration("breaker-cooldown", supplier.DefaultBreakerCooldown, "how long to pause lookups once the circuit breaker opens, before a probe request")
+	enrich := flag.Bool("enrich", false, "follow each supplier's link to add its page title to results (roughly doubles requests)")
+	enrichConcurrency := flag.Int("enrich-concurrency", supplier.DefaultEnrichConcurrency, "number of supplier links fetched concurrently with -enrich")
+	byOutwardCode := flag.Bool("by-outward-code", false, "APPROXIMATE: look up one postcode per outward code (e.g. BS1) and copy its supplier to the rest")
+	cacheSize := flag.Int("cache-size", supplier.DefaultCacheSize, "postcodes whose results are kept in memory to answer duplicates without a request (0 to disable)")
+	minDelay := flag.Duration("min-delay", 0, "shortest random pause each worker takes before a lookup, e.g. 200ms")
+	maxDelay := flag.Duration("max-delay", 0, "longest random pause each worker takes before a lookup, e.g. 800ms (0 for no pause)")
+	requestRate := flag.Float64("rate", 0, "maximum requests per second across all workers (0 for unlimited)")
+	var headers headerFlag
+	flag.Var(&headers, "header", "extra \"Name: value\" header sent with each lookup, overriding the defaults (repeatable; an empty value removes the header)")
+	userAgentsFile := flag.String("user-agents-file", "", "file of newline-delimited User-Agent strings to rotate through")
+	insecureSkipVerify := flag.Bool("insecure-skip-verify", false, "UNSAFE: don't verify TLS certificates, only for testing through an intercepting proxy")
+	caCert := flag.String("ca-cert", "", "PEM file of extra CA certificates to trust, e.g. a corporate proxy's, in addition to the system pool")
+	cassetteDir := flag.String("cassette-dir", "", "record responses to this directory and replay them on later runs, for testing without the network")
+	disableKeepAlive := flag.Bool("disable-keepalive", false, "open a fresh connection for every request, for proxies that drop reused connections")
+	proxyURL := flag.String("proxy", "", "proxy URL (http, https, or socks5), overriding HTTP_PROXY/HTTPS_PROXY")
+	endpoint := flag.String("endpoint", supplier.DefaultEndpointURL, "URL the lookup form is submitted to")
+	formURL := flag.String("form-url", supplier.DefaultFormURL, "page the form_build_id token is read from")
+	formID := flag.String("form-id", supplier.DefaultFormID, "Drupal form_id submitted with each lookup")
+	timeout := flag.Duration("timeout", supplier.DefaultTimeout, "timeout for each HTTP request (0 for none)")
+	logLevel := flag.String("log-level", "info", "minimum log level: debug, info, warn, or error")
+	verbose := flag.Bool("verbose", false, "log per-request detail, shorthand for -log-level debug")
+	quiet := flag.Bool("quiet", false, "only log errors, shorthand for -log-level error; the run summary is still printed")
+	logFile := flag.String("log-file", "", "also write logs to this file, at -log-level even with -quiet")
+	logTruncate := flag.Bool("log-truncate", false, "truncate -log-file at startup instead of appending to it")
+	logFormat := flag.String("log-format", "text", "log output format: text or json")
+	extractor := flag.String("extractor", "wateruk", "parser for the supplier markup: wateruk")
+	selectorsFile := flag.String("selectors-file", "", "YAML or JSON file of CSS selectors (name, block, phone, link, email, address) overriding the defaults")
+	debugDir := flag.String("debug-dir", "", "save the raw response for postcodes whose supplier couldn't be parsed to this directory")
+	debugAddr := flag.String("debug-addr", "", "serve pprof and expvar debug endpoints on this address, e.g. :6060 (bound to localhost unless a host is given)")
+	metricsAddr := flag.String("metrics-addr", "", "serve Prometheus metrics at /metrics on this address, e.g. :9090")
+	webhookURL := flag.String("webhook-url", "", "POST each result as JSON to this URL as it completes; failed deliveries are logged, not fatal")
+	notifyWebhook := flag.String("notify-webhook", "", "Slack or Discord incoming webhook told when the run completes or fails")
+	summaryFile := flag.String("summary", "", "also write the run summary as JSON to this file")
+	dryRun := flag.Bool("dry-run", false, "list the files and postcodes that would be processed without making requests")
+	limit := flag.Int("limit", 0, "stop after attempting this many postcodes (0 for no limit)")
+	processedIndex := flag.String("processed-index", "", "on-disk index of processed postcodes, checked instead of loading every earlier result at startup (rebuilt when stale)")
+	refetchOlderThan := flag.Duration("refetch-older-than", 0, "look up postcodes again when their stored result is older than this (0 to never refetch)")
+	maxRuntime := flag.Duration("max-runtime", 0, "stop cleanly, saving progress, once the run has taken this long (0 for no limit)")
+	maxConsecutiveFailures := flag.Int("max-consecutive-failures", 0, "stop the run, saving progress, once this many postcodes fail in a row (0 to never stop)")
+	resetDeadLetter := flag.Bool("reset-dead-letter", false, "clear -dead-letter-file so its postcodes are attempted again, by -retry-failed or a normal run")
+	retryFailed := flag.Bool("retry-failed", false, "only re-attempt the postcodes recorded in -failed-file")
+	healthcheck := flag.Bool("healthcheck", false, "look up -healthcheck-postcode to check the endpoint, token, and parser work, then exit")
+	healthcheckPostcode := flag.String("healthcheck-postcode", defaultHealthcheckPostcode, "known-good postcode looked up by -healthcheck")
+	showVersion := flag.Bool("version", false, "print the version, commit, and build date, then exit")
+	configFile := flag.String("config", "", "YAML or JSON file of settings keyed by flag name; explicit flags take precedence")
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Println(buildInfo())
+		return
 	}
 
-	if err := os.WriteFile(progressFile, data, 0644); err != nil {
-		return fmt.Errorf("error writing progress file: %v", err)
-	}
-
-	return nil
-}
-
-// loadExistingResults loads any existing results from the results file
-func loadExistingResults() ([]PostcodeResult, error) {
-	data, err := os.ReadFile(resultsFile)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return []PostcodeResult{}, nil
+	if *configFile != "" {
+		if err := applyConfigFile(flag.CommandLine, *configFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
 		}
-		return nil, fmt.Errorf("error reading results file: %v", err)
 	}
 
-	var results []PostcodeResult
-	if err := json.Unmarshal(data, &results); err != nil {
-		return nil, fmt.Errorf("error parsing results file: %v", err)
+	// -quiet only quietens the console; a log file keeps the full level
+	level, consoleLevel := *logLevel, *logLevel
+	switch {
+	case *verbose && *quiet:
+		fmt.Fprintln(os.Stderr, "-verbose and -quiet can't be used together")
+		os.Exit(2)
+	case *verbose:
+		level, consoleLevel = "debug", "debug"
+	case *quiet:
+		consoleLevel = "error"
 	}
-
-	return results, nil
-}
-
-func main() {
-	// Load progress from previous run
-	progress, err := loadProgress()
+	handler, err := newHandler(os.Stderr, consoleLevel, *logFormat)
 	if err != nil {
-		log.Fatalf("Error loading progress: %v", err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
+	if *logFile != "" {
+		file, err := openLogFile(*logFile, *logTruncate)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		defer file.Close()
 
-	// Load any existing results
-	existingResults, err := loadExistingResults()
-	if err != nil {
-		log.Fatalf("Error loading existing results: %v", err)
+		fileHandler, err := newHandler(file, level, *logFormat)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		handler = teeHandler{handler, fileHandler}
 	}
+	slog.SetDefault(slog.New(handler))
 
-	// Create a map of processed postcodes for quick lookup
-	processedPostcodes := make(map[string]bool)
-	for _, result := range existingResults {
-		processedPostcodes[result.Postcode] = true
+	// Say how the run ended, even when it ends in a fatal error
+	var notifier *runNotifier
+	if *notifyWebhook != "" {
+		notifier = newRunNotifier(*notifyWebhook)
+		beforeFatalExit = notifier.notify
 	}
+	startedAt := time.Now()
 
-	// Get list of CSV files
-	files, err := filepath.Glob(filepath.Join(postcodeDir, "*.csv"))
-	if err != nil {
-		log.Fatalf("Error reading directory: %v", err)
+	if *concurrency < 1 {
+		fatal("Invalid concurrency: must be at least 1", "concurrency", *concurrency)
 	}
+	slog.Info("Using concurrency", "concurrency", *concurrency)
 
-	// Sort files to ensure consistent ordering
-	sort.Strings(files)
-
-	// Find starting point based on progress
-	startIdx := 0
-	if progress.LastFile != "" {
-		for i, file := range files {
-			if filepath.Base(file) == progress.LastFile {
-				startIdx = i
-				break
-			}
+	// The HTTP client keeps a connection per worker, so size it for the most there can be
+	poolSize := *concurrency
+	if *adaptive {
+		if *maxConcurrency < *concurrency {
+			fatal("Invalid max concurrency: must be at least -concurrency", "max_concurrency", *maxConcurrency, "concurrency", *concurrency)
 		}
+		poolSize = *maxConcurrency
+		slog.Info("Adapting concurrency", "max_concurrency", *maxConcurrency)
 	}
 
-	// Process each file from the last known position
-	var results []PostcodeResult
-	results = append(results, existingResults...)
-
-	for i := startIdx; i < len(files); i++ {
-		file := files[i]
-		filename := filepath.Base(file)
-		log.Printf("Processing file: %s", filename)
-
-		postcodes, err := getPostcodesFromCSV(file)
-		if err != nil {
-			log.Printf("Error reading CSV file %s: %v", file, err)
-			continue
-		}
-
-		// Find starting postcode in current file
-		startPostcodeIdx := 0
-		if filename == progress.LastFile && progress.LastPostcode != "" {
-			for j, pc := range postcodes {
-				if pc == progress.LastPostcode {
-					startPostcodeIdx = j + 1 // Start from the NEXT postcode
-					if startPostcodeIdx < len(postcodes) {
-						log.Printf("Resuming from postcode %s (after %s)", postcodes[startPostcodeIdx], pc)
-					}
-					break
-				}
-			}
-		}
-
-		// Create channels for concurrent processing
-		resultsChan := make(chan PostcodeResult, maxGoroutines)
-		errorsChan := make(chan error, maxGoroutines)
-		semaphore := make(chan struct{}, maxGoroutines)
-		var wg sync.WaitGroup
-
-		// Process postcodes with concurrent workers
-		for j := startPostcodeIdx; j < len(postcodes); j++ {
-			postcode := postcodes[j]
-
-			// Skip if already processed
-			if processedPostcodes[postcode] {
-				log.Printf("Skipping already processed postcode: %s", postcode)
-				continue
-			}
-
-			wg.Add(1)
-			semaphore <- struct{}{} // Acquire semaphore
-
-			go func(pc string, idx int) {
-				defer wg.Done()
-				defer func() { <-semaphore }() // Release semaphore
-
-				result := getSupplierForPostcodeWithRetries(pc, maxRetries)
-				resultsChan <- result
-
-				// Update progress
-				if idx > startPostcodeIdx {
-					progress.LastFile = filename
-					progress.LastPostcode = pc
-					if err := saveProgress(progress); err != nil {
-						errorsChan <- fmt.Errorf("error saving progress for postcode %s: %v", pc, err)
-					}
-				}
-			}(postcode, j)
-
-			// Wait for all goroutines to complete before moving to next batch
-			if j%maxGoroutines == maxGoroutines-1 || j == len(postcodes)-1 {
-				go func() {
-					wg.Wait()
-					close(resultsChan)
-				}()
-
-				// Collect results
-				for result := range resultsChan {
-					if result.Supplier != "" && result.Supplier != "Not Found" {
-						processedPostcodes[result.Postcode] = true
-						results = append(results, result)
-					}
-				}
-
-				// Save results periodically
-				if len(results)%10 == 0 {
-					saveResultsToJSON(results, resultsFile)
-				}
-
-				// Check for errors
-				select {
-				case err := <-errorsChan:
-					log.Printf("Error during processing: %v", err)
-				default:
-				}
-
-				// Reset channels for next batch
-				resultsChan = make(chan PostcodeResult, maxGoroutines)
-				errorsChan = make(chan error, maxGoroutines)
-			}
-		}
-
-		// Save results after completing each file
-		saveResultsToJSON(results, resultsFile)
+	if *enrichConcurrency < 1 {
+		fatal("Invalid enrich concurrency: must be at least 1", "enrich_concurrency", *enrichConcurrency)
+	}
 
-		// If we've completed a file, clear the last postcode
-		if i < len(files)-1 {
-			progress.LastPostcode = ""
-			if err := saveProgress(progress); err != nil {
-				log.Printf("Error saving progress: %v", err)
-			}
-		}
+	if *retries < 1 {
+		fatal("Invalid retries: must be at least 1", "retries", *retries)
+	}
+	if *retryDelay <= 0 || *maxRetryDelay < *retryDelay {
+		fatal("Invalid retry delays: need 0 < retry-delay <= max-retry-delay", "retry_delay", *retryDelay, "max_retry_delay", *maxRetryDelay)
 	}
 
-	// Mark as completed
-	progress.Completed = true
-	if err := saveProgress(progress); err != nil {
-		log.Printf("Error saving final progress: %v", err)
+	if *timeout < 0 {
+		fatal("Invalid timeout: must not be negative", "timeout", *timeout)
 	}
 
-	log.Println("Processing completed successfully")
-}
+	if *maxRuntime < 0 {
+		fatal("Invalid max runtime: must not be negative", "max_runtime", *maxRuntime)
+	}
 
-// getPostcodesFromCSV reads a single CSV file and extracts postcodes
-func getPostcodesFromCSV(filePath string) ([]string, error) {
-	var postcodes []string
+	client, err := supplier.NewHTTPClient(supplier.ClientOptions{
+		ProxyURL:    *proxyURL,
+		Timeout:     *timeout,
+		Concurrency: poolSize,
 
-	// Open the CSV file
-	csvFile, err := os.Open(filePath)
+		InsecureSkipVerify: *insecureSkipVerify,
+		CACertFile:         *caCert,
+		DisableKeepAlives:  *disableKeepAlive,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("could not open file: %v", err)
+		fatal("Error configuring HTTP client", "err", err)
 	}
-	defer csvFile.Close()
-
-	reader := csv.NewReader(csvFile)
-
-	// Read each row of the CSV
-	for {
-		record, err := reader.Read()
-		if err == io.EOF {
-			break
-		}
+	if *insecureSkipVerify {
+		slog.Warn("TLS certificate verification is DISABLED: responses could come from anyone, only use this for testing")
+	}
+	if *cassetteDir != "" {
+		client.Transport, err = supplier.NewCassetteTransport(*cassetteDir, client.Transport)
 		if err != nil {
-			return nil, fmt.Errorf("error reading CSV file: %v", err)
+			fatal("Error configuring cassettes", "err", err)
 		}
-
-		// Extract postcode from the first column and remove quotes if present
-		postcode := strings.Trim(record[0], "\"")
-		postcodes = append(postcodes, postcode)
+		slog.Info("Recording and replaying responses", "dir", *cassetteDir)
 	}
-
-	return postcodes, nil
-}
-
-// saveResultsToJSON saves the results slice into a JSON file
-func saveResultsToJSON(results []PostcodeResult, filename string) {
-	jsonData, err := json.MarshalIndent(results, "", "  ")
+	// The run's counters are published globally here, for the debug and metrics endpoints
+	metrics, err := supplier.NewMetrics(prometheus.DefaultRegisterer)
 	if err != nil {
-		log.Fatalf("Error marshalling results to JSON: %v", err)
+		fatal("Error creating metrics", "err", err)
+	}
+	expvar.Publish("supplier", metrics.Vars())
+
+	fetcher := supplier.NewFetcher()
+	fetcher.Client = client
+	fetcher.Metrics = metrics
+	fetcher.Timeout = *timeout
+	fetcher.Endpoint = *endpoint
+	fetcher.FormURL = *formURL
+	fetcher.FormID = *formID
+	fetcher.Retries = *retries
+	fetcher.RetryDelay = *retryDelay
+	fetcher.MaxRetryDelay = *maxRetryDelay
+	if *cacheSize < 0 {
+		fatal("Invalid cache size: must not be negative", "cache_size", *cacheSize)
 	}
+	if *cacheSize > 0 {
+		fetcher.Cache = supplier.NewResultCache(*cacheSize)
+	}
+	headers.apply(fetcher.Headers)
 
-	// Write JSON data to a file
-	err = os.WriteFile(filename, jsonData, 0644)
+	selectors := supplier.DefaultSelectors()
+	if *selectorsFile != "" {
+		selectors, err = supplier.LoadSelectors(*selectorsFile)
+		if err != nil {
+			fatal("Error loading selectors", "err", err)
+		}
+	}
+	fetcher.Extractor, err = supplier.NewExtractor(*extractor, selectors)
 	if err != nil {
-		log.Fatalf("Error writing to JSON file: %v", err)
+		fatal("Error configuring extractor", "err", err)
 	}
 
-	fmt.Printf("Results saved to %s\n", filename)
-}
-
-// getSupplierForPostcodeWithRetries performs the POST request with retries
-func getSupplierForPostcodeWithRetries(postcode string, retries int) PostcodeResult {
-	var result PostcodeResult
-
-	for i := 0; i < retries; i++ {
-		result = getSupplierForPostcode(postcode)
-
-		// Check if the supplier was found
-		if result.Supplier != "Not Found" {
-			fmt.Printf("[Postcode %s] Successful result on attempt %d: %s\n", postcode, i+1, result.Supplier)
-			return result
+	// Create the debug directory up front rather than on the first parse miss
+	if *debugDir != "" {
+		if err := os.MkdirAll(*debugDir, 0755); err != nil {
+			fatal("Error creating debug directory", "dir", *debugDir, "err", err)
 		}
+		fetcher.DebugDir = *debugDir
+	}
 
-		// Log the attempt and result
-		fmt.Printf("[Postcode %s] Attempt %d: Extracted supplier: %s\n", postcode, i+1, result.Supplier)
+	proxy, err := supplier.ProxyFor(client, fetcher.Endpoint)
+	if err != nil {
+		fatal("Error resolving proxy", "err", err)
+	}
+	if proxy != nil {
+		slog.Info("Using proxy", "proxy", proxy.Redacted())
+	} else {
+		slog.Info("No proxy configured, connecting directly")
+	}
 
-		// Wait before retrying
-		time.Sleep(2 * time.Second)
+	if *byOutwardCode {
+		slog.Warn("Copying each outward code's supplier to all its postcodes: results are approximate where suppliers don't follow outward codes")
+	}
+	if *enrich {
+		slog.Info("Enriching results from supplier links", "enrich_concurrency", *enrichConcurrency)
+	}
+	if *maxDelay > 0 {
+		slog.Info("Pausing before each lookup", "min_delay", *minDelay, "max_delay", *maxDelay)
 	}
 
-	fmt.Printf("[Postcode %s] All attempts failed. Last result: %s\n", postcode, result.Supplier)
-	return result
-}
+	if *requestRate < 0 {
+		fatal("Invalid rate: must not be negative", "rate", *requestRate)
+	}
+	if *requestRate > 0 {
+		fetcher.Limiter = rate.NewLimiter(rate.Limit(*requestRate), 1)
+		slog.Info("Limiting request rate", "per_second", *requestRate)
+	}
 
-// getSupplierForPostcode performs the POST request to get the supplier info for a given postcode
-func getSupplierForPostcode(postcode string) PostcodeResult {
-	endpointURL := "https://www.water.org.uk/customers/find-your-supplier?ajax_form=1&_wrapper_format=drupal_ajax"
+	if *breakerFailures < 0 || *breakerCooldown <= 0 {
+		fatal("Invalid circuit breaker: need breaker-failures >= 0 and breaker-cooldown > 0", "breaker_failures", *breakerFailures, "breaker_cooldown", *breakerCooldown)
+	}
+	if *breakerFailures > 0 {
+		fetcher.Breaker = supplier.NewCircuitBreaker(*breakerFailures, *breakerCooldown)
+	}
 
-	// Data payload for the POST request
-	formData := url.Values{
-		"postcode":                  {postcode},
-		"form_build_id":             {"form-L5pD8ZkLBHXVZ8bFpzrd3oIEPn94DYlRz298X2_IG1s"},
-		"form_id":                   {"wateruk_find_my_supplier"},
-		"_triggering_element_name":  {"op"},
-		"_triggering_element_value": {"Submit"},
-		"_drupal_ajax":              {"1"},
+	if *userAgentsFile != "" {
+		agents, err := supplier.LoadUserAgents(*userAgentsFile)
+		if err != nil {
+			fatal("Error loading user agents", "err", err)
+		}
+		fetcher.UserAgents = agents
+		slog.Info("Rotating user agents", "count", len(agents))
 	}
 
-	fmt.Printf("[Postcode %s] Sending request...\n", postcode)
+	if *debugAddr != "" {
+		server, err := startDebugServer(*debugAddr)
+		if err != nil {
+			fatal("Error starting debug server", "err", err)
+		}
+		defer server.Close()
+	}
 
-	// Create the POST request
-	req, err := http.NewRequest("POST", endpointURL, strings.NewReader(formData.Encode()))
-	if err != nil {
-		fmt.Printf("Error creating request for postcode %s: %v\n", postcode, err)
-		return PostcodeResult{Postcode: postcode}
+	if *metricsAddr != "" {
+		server, err := startMetricsServer(*metricsAddr)
+		if err != nil {
+			fatal("Error starting metrics server", "err", err)
+		}
+		defer server.Close()
 	}
 
-	// Set minimal headers
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=UTF-8")
-	req.Header.Set("User-Agent", "Mozilla/5.0")
+	// Cancel lookups on SIGINT/SIGTERM, forcing an exit if in-flight work still hangs or a
+	// second signal arrives
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	// Perform the POST request
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Printf("Error sending request for postcode %s: %v\n", postcode, err)
-		return PostcodeResult{Postcode: postcode}
+	// Bound the whole run, timed from when it started, so scheduled runs fit their window
+	if *maxRuntime > 0 {
+		var cancelRuntime context.CancelFunc
+		ctx, cancelRuntime = context.WithDeadline(ctx, startedAt.Add(*maxRuntime))
+		defer cancelRuntime()
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("Received non-OK HTTP status for postcode %s: %s\n", postcode, resp.Status)
-		return PostcodeResult{Postcode: postcode}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		slog.Info("Received signal, cancelling in-flight postcodes and saving", "signal", sig)
+		cancel()
+		time.AfterFunc(shutdownGracePeriod, func() {
+			slog.Error("In-flight postcodes did not finish in time, forcing exit", "grace_period", shutdownGracePeriod)
+			os.Exit(1)
+		})
+
+		sig = <-signals
+		slog.Error("Received second signal, exiting without waiting", "signal", sig)
+		os.Exit(1)
+	}()
+
+	if *healthcheck {
+		if err := runHealthcheck(ctx, fetcher, *healthcheckPostcode); err != nil {
+			fatal("Healthcheck failed", "err", err)
+		}
+		return
 	}
 
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Printf("Error reading response for postcode %s: %v\n", postcode, err)
-		return PostcodeResult{Postcode: postcode}
+	if *compressOutput && !strings.HasSuffix(*output, ".gz") {
+		*output += ".gz"
 	}
 
-	// Parse the JSON response
-	var ajaxResponse []AjaxResponse
-	if err := json.Unmarshal(body, &ajaxResponse); err != nil {
-		fmt.Printf("Error parsing JSON response for postcode %s: %v\n", postcode, err)
-		return PostcodeResult{Postcode: postcode}
+	// With -dir - postcodes are read one per line from standard input instead of CSV files
+	var input io.Reader
+	if *postcodeDir == "-" {
+		input = os.Stdin
 	}
 
-	// Extract supplier details from the HTML in the data field
-	supplier := extractSupplierDetails(ajaxResponse[2].Data)
-	fmt.Printf("[Postcode %s] Extracted Results: %s...\n", postcode, supplier["link"])
-	return PostcodeResult{
-		Postcode: postcode,
-		Supplier: supplier["name"],
-		Phone:    supplier["phone"],
-		Link:     supplier["link"],
+	// Stream results to the webhook as they complete, independently of saving them
+	var onResult func(supplier.PostcodeResult)
+	var webhook *resultWebhook
+	if *webhookURL != "" && !*dryRun {
+		webhook = startResultWebhook(*webhookURL)
+		onResult = webhook.Send
+		slog.Info("Posting results to webhook")
 	}
-}
 
-// extractSupplierDetails extracts the supplier name, phone, and link from the HTML response
-func extractSupplierDetails(body string) map[string]string {
-	details := make(map[string]string)
-
-	// Regular expressions to extract the supplier name, phone, and link
-	reName := regexp.MustCompile(`<h2 class="supplier__name">(.+?)</h2>`)
-	rePhone := regexp.MustCompile(`<p class="supplier__phone">General enquiries call <b>(.+?)</b></p>`)
-	reLink := regexp.MustCompile(`<a class="supplier__link.+?href="(.+?)".*?>`)
-
-	// Find matches
-	nameMatch := reName.FindStringSubmatch(body)
-	phoneMatch := rePhone.FindStringSubmatch(body)
-	linkMatch := reLink.FindStringSubmatch(body)
-
-	// Extracted details
-	if len(nameMatch) > 1 {
-		details["name"] = nameMatch[1]
-	} else {
-		details["name"] = "Not Found"
+	summary, err := supplier.Run(ctx, supplier.Options{
+		Fetcher:             fetcher,
+		Concurrency:         *concurrency,
+		Adaptive:            *adaptive,
+		MaxConcurrency:      *maxConcurrency,
+		ByOutwardCode:       *byOutwardCode,
+		Enrich:              *enrich,
+		EnrichConcurrency:   *enrichConcurrency,
+		MinDelay:            *minDelay,
+		MaxDelay:            *maxDelay,
+		Dir:                 *postcodeDir,
+		File:                *postcodeFile,
+		StartFile:           *startFile,
+		EndFile:             *endFile,
+		Input:               input,
+		HasHeader:           *hasHeader,
+		PostcodeColumn:      *postcodeColumn,
+		Delimiter:           *delimiter,
+		Format:              *format,
+		Store:               *storeType,
+		Output:              *output,
+		Database:            *database,
+		ProgressFile:        *progressFile,
+		FailedPostcodesFile: *failedFile,
+		DeadLetterFile:      *deadLetterFile,
+		ManifestFile:        *manifestFile,
+		DryRun:              *dryRun,
+		Limit:               *limit,
+		RetryFailed:         *retryFailed,
+		ResetDeadLetter:     *resetDeadLetter,
+		RefetchOlderThan:    *refetchOlderThan,
+		SkipFile:            *skipFile,
+		OnlyFile:            *onlyFile,
+		Force:               *force,
+		NoResume:            *noResume,
+
+		ProcessedIndex:         *processedIndex,
+		MaxConsecutiveFailures: *maxConsecutiveFailures,
+		Metrics:                metrics,
+		OnResult:               onResult,
+	})
+	if webhook != nil {
+		webhook.Close()
 	}
 
-	if len(phoneMatch) > 1 {
-		details["phone"] = phoneMatch[1]
-	} else {
-		details["phone"] = "Not Found"
+	// Report what the run did however it ends
+	summary.Version = buildInfo()
+	if notifier != nil {
+		notifier.summary = summary
 	}
-
-	if len(linkMatch) > 1 {
-		details["link"] = linkMatch[1]
-	} else {
-		details["link"] = "Not Found"
+	if !*dryRun {
+		summary.Print(os.Stdout)
+		if *summaryFile != "" {
+			if err := summary.Save(*summaryFile); err != nil {
+				slog.Error("Error saving summary", "err", err)
+			}
+		}
+	}
+	if err != nil {
+		fatal("Error running lookups", "err", err)
+	}
+	if notifier != nil && !*dryRun {
+		notifier.notify("")
 	}
-
-	return details
 }
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+
+	"github.com/MaxWCode/TappedIN/supplier"
+)
+
+// runMerge implements the merge subcommand, combining results files from several runs into
+// a single deduplicated, sorted file
+func runMerge(args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	output := fs.String("output", supplier.ResultsFile, "merged results file, written as CSV or NDJSON for .csv or .ndjson names and JSON otherwise")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: merge [-output file] results-file...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no results files to merge")
+	}
+
+	var sets [][]supplier.PostcodeResult
+	for _, filename := range fs.Args() {
+		results, err := supplier.LoadResultsFile(filename)
+		if err != nil {
+			return fmt.Errorf("error loading %s: %v", filename, err)
+		}
+		slog.Info("Loaded results", "file", filename, "results", len(results))
+		sets = append(sets, results)
+	}
+
+	merged := supplier.MergeResults(sets...)
+	if err := supplier.SaveResultsFile(merged, *output); err != nil {
+		return err
+	}
+
+	slog.Info("Merged results", "files", len(sets), "results", len(merged), "output", *output)
+	return nil
+}
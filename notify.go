@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/MaxWCode/TappedIN/supplier"
+)
+
+// runNotifier posts a message to a Slack or Discord incoming webhook when a run ends,
+// whether it completed or failed
+type runNotifier struct {
+	url     string
+	client  *http.Client
+	summary *supplier.RunSummary // Counts to report, once the run has produced them
+}
+
+// newRunNotifier creates a notifier posting to url
+func newRunNotifier(url string) *runNotifier {
+	return &runNotifier{url: url, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+// notify posts how the run ended; failure is empty when it completed. Delivery failures are
+// only logged, as the run is over either way.
+func (n *runNotifier) notify(failure string) {
+	var msg strings.Builder
+	if failure == "" {
+		msg.WriteString("Water supplier run completed")
+	} else {
+		fmt.Fprintf(&msg, "Water supplier run failed: %s", failure)
+	}
+	if s := n.summary; s != nil {
+		duration := s.FinishedAt.Sub(s.StartedAt).Round(time.Second)
+		fmt.Fprintf(&msg, "\nProcessed %d (found %d, not found %d, errored %d), skipped %d, in %s",
+			s.Processed, s.Found, s.NotFound, s.Errored, s.Skipped, duration)
+	}
+
+	// Slack reads text and Discord reads content, each ignoring the other
+	body, err := json.Marshal(map[string]string{"text": msg.String(), "content": msg.String()})
+	if err != nil {
+		slog.Warn("Error marshalling notification", "err", err)
+		return
+	}
+	if err := postJSON(context.Background(), n.client, n.url, body); err != nil {
+		slog.Warn("Error sending notification", "err", err)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/MaxWCode/TappedIN/supplier"
+)
+
+// runReport implements the report subcommand, printing how many postcodes each supplier covers
+func runReport(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	input := fs.String("input", supplier.ResultsFile, "results file to report on (.json or .ndjson)")
+	format := fs.String("format", "text", "report format: text or json")
+	fs.Parse(args)
+
+	results, err := supplier.LoadResultsFile(*input)
+	if err != nil {
+		return err
+	}
+	report := supplier.CountSuppliers(results)
+
+	switch *format {
+	case "text":
+		return supplier.WriteSupplierReport(os.Stdout, report)
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	default:
+		return fmt.Errorf("invalid report format %q: must be text or json", *format)
+	}
+}
@@ -0,0 +1,97 @@
+package supplier
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// DefaultMaxConcurrency caps adaptive concurrency when no maximum is given
+const DefaultMaxConcurrency = 16
+
+// adaptiveLimiter bounds how many lookups run at once, adjusting the bound with a simple
+// AIMD scheme: it grows by one after a full window of healthy lookups and halves when a
+// lookup fails or latency climbs well above the best seen, so throughput stays high
+// without tipping the site into throttling. It is safe for concurrent use.
+type adaptiveLimiter struct {
+	max int
+
+	mu           sync.Mutex
+	limit        int           // Lookups currently allowed at once
+	inFlight     int           // Lookups currently running
+	healthy      int           // Healthy lookups since the limit last changed
+	latency      time.Duration // Smoothed lookup latency
+	baseline     time.Duration // Lowest smoothed latency seen, the uncongested latency
+	lastDecrease time.Time
+	changed      chan struct{} // Closed, and replaced, whenever a slot frees up
+}
+
+// newAdaptiveLimiter creates a limiter starting at start lookups at once, never exceeding max
+func newAdaptiveLimiter(start, max int) *adaptiveLimiter {
+	return &adaptiveLimiter{max: max, limit: min(start, max), changed: make(chan struct{})}
+}
+
+// acquire waits for a free slot, returning the context's error if ctx is cancelled first
+func (l *adaptiveLimiter) acquire(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.inFlight < l.limit {
+			l.inFlight++
+			l.mu.Unlock()
+			return nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release frees the slot taken by a lookup that took latency, adjusting the limit by
+// whether it was healthy
+func (l *adaptiveLimiter) release(latency time.Duration, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+	defer l.notify()
+
+	if l.latency == 0 {
+		l.latency = latency
+	} else {
+		l.latency = (l.latency*4 + latency) / 5
+	}
+	if l.baseline == 0 || l.latency < l.baseline {
+		l.baseline = l.latency
+	}
+
+	// Back off at most once per round trip, as lookups already in flight report the same
+	// congestion
+	if !ok || l.latency > 2*l.baseline {
+		if l.limit > 1 && time.Since(l.lastDecrease) > l.latency {
+			l.limit = max(l.limit/2, 1)
+			l.healthy = 0
+			l.lastDecrease = time.Now()
+			slog.Info("Reducing concurrency", "concurrency", l.limit, "latency", l.latency, "baseline", l.baseline, "failed", !ok)
+		}
+		return
+	}
+
+	l.healthy++
+	if l.healthy >= l.limit && l.limit < l.max {
+		l.limit++
+		l.healthy = 0
+		slog.Debug("Increasing concurrency", "concurrency", l.limit, "latency", l.latency)
+	}
+}
+
+// notify wakes every goroutine waiting for a slot; the caller must hold l.mu
+func (l *adaptiveLimiter) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
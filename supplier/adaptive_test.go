@@ -0,0 +1,101 @@
+package supplier
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// acquireWithin calls l.acquire, giving up after d, and returns its error
+func acquireWithin(l *adaptiveLimiter, d time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return l.acquire(ctx)
+}
+
+func TestAdaptiveLimiterBoundsInFlight(t *testing.T) {
+	l := newAdaptiveLimiter(4, 2)
+	if l.limit != 2 {
+		t.Fatalf("limit = %d, want start capped at max 2", l.limit)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := acquireWithin(l, 10*time.Millisecond); err != nil {
+			t.Fatalf("acquire() %d error = %v, want nil", i+1, err)
+		}
+	}
+	if err := acquireWithin(l, 10*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("acquire() over the limit error = %v, want deadline exceeded", err)
+	}
+
+	// Releasing a slot wakes a waiter
+	acquired := make(chan error, 1)
+	go func() { acquired <- acquireWithin(l, time.Second) }()
+	time.Sleep(10 * time.Millisecond)
+	l.release(time.Millisecond, true)
+	if err := <-acquired; err != nil {
+		t.Errorf("acquire() after release error = %v, want nil", err)
+	}
+}
+
+func TestAdaptiveLimiterIncrease(t *testing.T) {
+	l := newAdaptiveLimiter(2, 3)
+
+	// The limit grows by one after a full window of healthy lookups, up to the maximum
+	for _, want := range []int{2, 3, 3} {
+		if l.limit != want {
+			t.Fatalf("limit = %d, want %d", l.limit, want)
+		}
+		for i := 0; i < want-1; i++ {
+			l.acquire(context.Background())
+			l.release(10*time.Millisecond, true)
+		}
+		l.acquire(context.Background())
+		l.release(10*time.Millisecond, true)
+	}
+}
+
+func TestAdaptiveLimiterDecrease(t *testing.T) {
+	l := newAdaptiveLimiter(8, 8)
+
+	// A failure halves the limit, but only once per round trip
+	l.acquire(context.Background())
+	l.release(time.Second, false)
+	if l.limit != 4 {
+		t.Fatalf("limit after a failure = %d, want 4", l.limit)
+	}
+	l.acquire(context.Background())
+	l.release(time.Second, false)
+	if l.limit != 4 {
+		t.Fatalf("limit after a second failure in the same round trip = %d, want 4", l.limit)
+	}
+
+	// Once the round trip has passed another failure halves it again, never below one
+	for _, want := range []int{2, 1, 1} {
+		l.lastDecrease = time.Now().Add(-time.Hour)
+		l.acquire(context.Background())
+		l.release(time.Second, false)
+		if l.limit != want {
+			t.Fatalf("limit = %d, want %d", l.limit, want)
+		}
+	}
+}
+
+func TestAdaptiveLimiterLatencySpike(t *testing.T) {
+	l := newAdaptiveLimiter(4, 4)
+	for i := 0; i < 3; i++ {
+		l.acquire(context.Background())
+		l.release(10*time.Millisecond, true)
+	}
+	if l.limit != 4 {
+		t.Fatalf("limit after healthy lookups = %d, want 4", l.limit)
+	}
+
+	// Healthy lookups whose latency climbs well above the baseline still back off
+	l.acquire(context.Background())
+	l.release(200*time.Millisecond, true)
+	if l.limit != 2 {
+		t.Errorf("limit after a latency spike = %d, want 2", l.limit)
+	}
+}
@@ -0,0 +1,105 @@
+package supplier
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Defaults for the circuit breaker pausing lookups during outages
+const (
+	DefaultBreakerFailures = 10
+	DefaultBreakerCooldown = 1 * time.Minute
+)
+
+// CircuitBreaker pauses lookups while the site looks to be down. After a run of
+// consecutive failed attempts it opens, holding back every request for a cooldown, then
+// lets a single probe request through: success closes it again, failure reopens it for
+// another cooldown. It is safe for concurrent use.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int           // Consecutive failed attempts
+	openUntil time.Time     // When an open breaker next lets a probe through
+	probing   bool          // Whether the probe request is in flight
+	changed   chan struct{} // Closed, and replaced, whenever the breaker's state changes
+}
+
+// NewCircuitBreaker creates a breaker that opens after threshold consecutive failures,
+// pausing requests for cooldown each time
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown, changed: make(chan struct{})}
+}
+
+// Wait blocks while the breaker is open, returning once a request may be made or with the
+// context's error if ctx is cancelled first
+func (b *CircuitBreaker) Wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		if b.failures < b.threshold {
+			b.mu.Unlock()
+			return nil
+		}
+		if !b.probing && !time.Now().Before(b.openUntil) {
+			b.probing = true
+			b.mu.Unlock()
+			slog.Info("Circuit breaker half-open, sending probe request")
+			return nil
+		}
+
+		// Wait out the cooldown, or for the probe in flight to report back
+		wait := time.Until(b.openUntil)
+		if b.probing {
+			wait = b.cooldown
+		}
+		changed := b.changed
+		b.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-changed:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// Record reports the outcome of a request, opening the breaker once failures reach the
+// threshold and closing it on success
+func (b *CircuitBreaker) Record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if success {
+		if b.failures >= b.threshold {
+			slog.Info("Circuit breaker closed, resuming requests")
+		}
+		b.failures = 0
+		b.probing = false
+		b.notify()
+		return
+	}
+
+	b.failures++
+	if b.failures < b.threshold {
+		return
+	}
+	if b.failures == b.threshold || b.probing {
+		slog.Warn("Circuit breaker open, pausing requests", "failures", b.failures, "cooldown", b.cooldown)
+	}
+	b.openUntil = time.Now().Add(b.cooldown)
+	b.probing = false
+	b.notify()
+}
+
+// notify wakes every goroutine waiting on the breaker; the caller must hold b.mu
+func (b *CircuitBreaker) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
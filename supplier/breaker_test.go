@@ -0,0 +1,79 @@
+package supplier
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitWithin calls b.Wait, giving up after d, and returns its error
+func waitWithin(b *CircuitBreaker, d time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return b.Wait(ctx)
+}
+
+func TestCircuitBreakerOpensAfterThreshold(t *testing.T) {
+	b := NewCircuitBreaker(3, time.Hour)
+
+	for i := 0; i < 2; i++ {
+		b.Record(false)
+		if err := waitWithin(b, 10*time.Millisecond); err != nil {
+			t.Fatalf("Wait() after %d failures error = %v, want nil", i+1, err)
+		}
+	}
+
+	// A success resets the run of failures
+	b.Record(true)
+	b.Record(false)
+	b.Record(false)
+	if err := waitWithin(b, 10*time.Millisecond); err != nil {
+		t.Fatalf("Wait() after a reset error = %v, want nil", err)
+	}
+
+	b.Record(false)
+	if err := waitWithin(b, 10*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait() on an open breaker error = %v, want deadline exceeded", err)
+	}
+}
+
+func TestCircuitBreakerProbe(t *testing.T) {
+	b := NewCircuitBreaker(1, 20*time.Millisecond)
+	b.Record(false)
+
+	// Once the cooldown passes a single probe is let through while the others wait
+	if err := waitWithin(b, time.Second); err != nil {
+		t.Fatalf("Wait() for probe error = %v, want nil", err)
+	}
+	if err := waitWithin(b, 10*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait() during probe error = %v, want deadline exceeded", err)
+	}
+
+	// A failed probe reopens the breaker for another cooldown
+	b.Record(false)
+	start := time.Now()
+	if err := waitWithin(b, time.Second); err != nil {
+		t.Fatalf("Wait() for second probe error = %v, want nil", err)
+	}
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Errorf("second probe let through after %s, want the cooldown", elapsed)
+	}
+
+	// A successful probe closes it, releasing waiters straight away
+	released := make(chan error, 1)
+	go func() { released <- waitWithin(b, time.Second) }()
+	time.Sleep(10 * time.Millisecond)
+	b.Record(true)
+	select {
+	case err := <-released:
+		if err != nil {
+			t.Errorf("Wait() after a successful probe error = %v, want nil", err)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Wait() not released by a successful probe")
+	}
+	if err := waitWithin(b, 10*time.Millisecond); err != nil {
+		t.Errorf("Wait() on a closed breaker error = %v, want nil", err)
+	}
+}
@@ -0,0 +1,56 @@
+package supplier
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DefaultCacheSize is how many postcodes' results a ResultCache holds when not set
+const DefaultCacheSize = 10000
+
+// ResultCache holds the most recently used definitive lookup results, so a postcode that
+// turns up again in the same run, even while its first lookup is still in flight, is
+// answered without another request. It is safe for concurrent use.
+type ResultCache struct {
+	size int
+
+	mu      sync.Mutex
+	order   *list.List               // Most recently used at the front
+	entries map[string]*list.Element // Elements hold PostcodeResult values, keyed by postcode
+}
+
+// NewResultCache creates a cache holding at most size results
+func NewResultCache(size int) *ResultCache {
+	return &ResultCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// get returns the cached result for postcode, marking it recently used
+func (c *ResultCache) get(postcode string) (PostcodeResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[postcode]
+	if !ok {
+		return PostcodeResult{}, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(PostcodeResult), true
+}
+
+// add caches result, evicting the least recently used result when full
+func (c *ResultCache) add(result PostcodeResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[result.Postcode]; ok {
+		elem.Value = result
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[result.Postcode] = c.order.PushFront(result)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(PostcodeResult).Postcode)
+	}
+}
@@ -0,0 +1,113 @@
+package supplier
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResultCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewResultCache(2)
+	c.add(PostcodeResult{Postcode: "SW1A 1AA", Supplier: "Thames Water"})
+	c.add(PostcodeResult{Postcode: "M1 1AE", Supplier: "United Utilities"})
+
+	// Using the first result makes the second the least recently used
+	if _, ok := c.get("SW1A 1AA"); !ok {
+		t.Fatal("get(SW1A 1AA) missed, want hit")
+	}
+	c.add(PostcodeResult{Postcode: "B33 8TH", Supplier: "Severn Trent"})
+
+	if _, ok := c.get("M1 1AE"); ok {
+		t.Error("get(M1 1AE) hit, want evicted")
+	}
+	for _, postcode := range []string{"SW1A 1AA", "B33 8TH"} {
+		if _, ok := c.get(postcode); !ok {
+			t.Errorf("get(%s) missed, want hit", postcode)
+		}
+	}
+
+	// Adding a cached postcode again replaces its result
+	c.add(PostcodeResult{Postcode: "SW1A 1AA", Supplier: "Affinity Water"})
+	if got, _ := c.get("SW1A 1AA"); got.Supplier != "Affinity Water" {
+		t.Errorf("get(SW1A 1AA) supplier = %q, want %q", got.Supplier, "Affinity Water")
+	}
+	if c.order.Len() != 2 || len(c.entries) != 2 {
+		t.Errorf("cache holds %d results, %d entries, want 2", c.order.Len(), len(c.entries))
+	}
+}
+
+func TestFetcherCacheHit(t *testing.T) {
+	var posts atomic.Int32
+	f := newTestFetcher(t, func(w http.ResponseWriter, r *http.Request) {
+		posts.Add(1)
+		writeSupplierResponse(w)
+	})
+	f.Cache = NewResultCache(DefaultCacheSize)
+
+	for i := 0; i < 3; i++ {
+		if result := f.getSupplierForPostcodeWithRetries(context.Background(), "SW1A 1AA"); result.Status != StatusFound {
+			t.Fatalf("lookup %d status = %s, want %s", i+1, result.Status, StatusFound)
+		}
+	}
+	if got := posts.Load(); got != 1 {
+		t.Errorf("submissions = %d, want 1", got)
+	}
+}
+
+func TestFetcherCacheSkipsFailures(t *testing.T) {
+	var posts atomic.Int32
+	f := newTestFetcher(t, func(w http.ResponseWriter, r *http.Request) {
+		posts.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	f.Cache = NewResultCache(DefaultCacheSize)
+
+	for i := 0; i < 2; i++ {
+		if result := f.getSupplierForPostcodeWithRetries(context.Background(), "SW1A 1AA"); result.Status != StatusNetworkError {
+			t.Fatalf("lookup %d status = %s, want %s", i+1, result.Status, StatusNetworkError)
+		}
+	}
+
+	// Each lookup submits and then resubmits with a refreshed token, as failures aren't cached
+	if got := posts.Load(); got != 4 {
+		t.Errorf("submissions = %d, want 4", got)
+	}
+}
+
+func TestFetcherCacheSharesInFlightLookups(t *testing.T) {
+	var posts atomic.Int32
+	release := make(chan struct{})
+	f := newTestFetcher(t, func(w http.ResponseWriter, r *http.Request) {
+		posts.Add(1)
+		<-release
+		writeSupplierResponse(w)
+	})
+	f.Cache = NewResultCache(DefaultCacheSize)
+
+	// Concurrent lookups of the same postcode wait for the one already in flight
+	const lookups = 5
+	results := make([]PostcodeResult, lookups)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = f.getSupplierForPostcodeWithRetries(context.Background(), "SW1A 1AA")
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for i, result := range results {
+		if result.Status != StatusFound {
+			t.Errorf("lookup %d status = %s, want %s", i+1, result.Status, StatusFound)
+		}
+	}
+	if got := posts.Load(); got != 1 {
+		t.Errorf("submissions = %d, want 1", got)
+	}
+}
@@ -0,0 +1,132 @@
+package supplier
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CassetteTransport records responses to a directory on first use and replays them
+// afterwards, so the whole fetch pipeline can run deterministically without the network.
+// Submissions are keyed by postcode and other requests, such as for the form page, by method
+// and URL, so a replayed run sees the same token its recorded submissions were made with.
+// Only successful responses are recorded, so a rate limit or outage while recording is
+// retried on the next run instead of being replayed forever.
+type CassetteTransport struct {
+	Dir  string            // Directory holding one cassette file per request
+	Next http.RoundTripper // Transport making requests that haven't been recorded yet
+}
+
+// cassette is a single recorded response
+type cassette struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   string      `json:"body"`
+}
+
+// NewCassetteTransport creates a transport recording to and replaying from dir, sending
+// requests not yet recorded through next
+func NewCassetteTransport(dir string, next http.RoundTripper) (*CassetteTransport, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating cassette directory: %v", err)
+	}
+	return &CassetteTransport{Dir: dir, Next: next}, nil
+}
+
+// RoundTrip replays the recorded response for req, or makes and records it if there isn't one
+func (t *CassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, err := cassetteKey(req)
+	if err != nil {
+		return nil, err
+	}
+	filename := filepath.Join(t.Dir, key+".json")
+
+	if data, err := os.ReadFile(filename); err == nil {
+		var c cassette
+		if err := json.Unmarshal(data, &c); err != nil {
+			return nil, fmt.Errorf("error parsing cassette %s: %v", filename, err)
+		}
+		slog.Debug("Replaying response", "cassette", filename)
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", c.Status, http.StatusText(c.Status)),
+			StatusCode:    c.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        c.Header,
+			Body:          io.NopCloser(strings.NewReader(c.Body)),
+			ContentLength: int64(len(c.Body)),
+			Request:       req,
+		}, nil
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading cassette: %v", err)
+	}
+
+	resp, err := t.Next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error reading response to record: %v", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		slog.Debug("Not recording unsuccessful response", "url", req.URL, "status", resp.StatusCode)
+		return resp, nil
+	}
+
+	data, err := json.MarshalIndent(cassette{Status: resp.StatusCode, Header: resp.Header, Body: string(body)}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling cassette: %v", err)
+	}
+	err = writeFileAtomic(filename, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error writing cassette: %v", err)
+	}
+	slog.Debug("Recorded response", "cassette", filename)
+	return resp, nil
+}
+
+// cassetteKey names the cassette for req: the postcode for form submissions, otherwise the
+// method and a hash of the URL, which tells the form page from supplier sites
+func cassetteKey(req *http.Request) (string, error) {
+	if req.Method != http.MethodPost || req.GetBody == nil {
+		return urlCassetteKey(req), nil
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return "", fmt.Errorf("error reading request body: %v", err)
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return "", fmt.Errorf("error reading request body: %v", err)
+	}
+	form, err := url.ParseQuery(string(data))
+	if err != nil || form.Get("postcode") == "" {
+		return urlCassetteKey(req), nil
+	}
+	return "postcode_" + strings.ReplaceAll(form.Get("postcode"), " ", "_"), nil
+}
+
+// urlCassetteKey names the cassette for a request other than a form submission by its method
+// and a short hash of its URL
+func urlCassetteKey(req *http.Request) string {
+	sum := sha256.Sum256([]byte(req.URL.String()))
+	return strings.ToLower(req.Method) + "_" + hex.EncodeToString(sum[:8])
+}
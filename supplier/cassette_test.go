@@ -0,0 +1,115 @@
+package supplier
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// offlineTransport fails every request, so a test replaying cassettes can't reach the network
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, fmt.Errorf("no cassette recorded for %s %s", req.Method, req.URL)
+}
+
+// TestRunReplaysCassettes runs the whole pipeline over responses recorded from the site
+// under testdata/cassettes
+func TestRunReplaysCassettes(t *testing.T) {
+	transport, err := NewCassetteTransport(filepath.Join("testdata", "cassettes"), offlineTransport{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := NewFetcher()
+	f.Client = &http.Client{Transport: transport}
+	f.RetryDelay = time.Millisecond
+	f.MaxRetryDelay = time.Millisecond
+
+	dir := t.TempDir()
+	opts := Options{
+		Fetcher:             f,
+		Input:               strings.NewReader("SW1A 1AA\nhp11bb\nZE3 9JZ\n"),
+		Output:              filepath.Join(dir, ResultsFile),
+		ProgressFile:        filepath.Join(dir, ProgressFile),
+		FailedPostcodesFile: filepath.Join(dir, FailedPostcodesFile),
+		DeadLetterFile:      filepath.Join(dir, DeadLetterFile),
+		ManifestFile:        filepath.Join(dir, ManifestFile),
+	}
+	summary, err := Run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if summary.Found != 2 || summary.NotFound != 1 || summary.Errored != 0 {
+		t.Errorf("summary found %d, not found %d, errored %d, want 2, 1, 0", summary.Found, summary.NotFound, summary.Errored)
+	}
+
+	results, err := LoadResultsFile(opts.Output)
+	if err != nil {
+		t.Fatalf("LoadResultsFile() error = %v", err)
+	}
+	want := []PostcodeResult{
+		{Postcode: "HP1 1BB", Supplier: "Affinity Water", Phone: "0345 357 2407", Link: "https://www.affinitywater.co.uk/",
+			SewerageSupplier: "Thames Water", SeweragePhone: "0800 316 9800", SewerageLink: "https://www.thameswater.co.uk/", Status: StatusFound},
+		{Postcode: "SW1A 1AA", Supplier: "Thames Water", Phone: "0800 316 9800", Link: "https://www.thameswater.co.uk/", Status: StatusFound},
+		{Postcode: "ZE3 9JZ", Supplier: "Not Found", Phone: "Not Found", Link: "Not Found", Status: StatusNotFound},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("results = %+v, want %d results", results, len(want))
+	}
+	for i, result := range results {
+		if result.FetchedAt.IsZero() {
+			t.Errorf("result for %s has no fetched_at", result.Postcode)
+		}
+		result.FetchedAt, result.HTTPStatus, result.Attempts = time.Time{}, 0, 0
+		if result != want[i] {
+			t.Errorf("result %d = %+v, want %+v", i, result, want[i])
+		}
+	}
+}
+
+func TestCassetteRecordsOnlySuccesses(t *testing.T) {
+	status := http.StatusTooManyRequests
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(status)
+		fmt.Fprint(w, "body")
+	}))
+	defer server.Close()
+
+	transport, err := NewCassetteTransport(t.TempDir(), http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: transport}
+	get := func() int {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// A failure is passed through but not recorded, so the next request tries again
+	if got := get(); got != http.StatusTooManyRequests {
+		t.Errorf("first status = %d, want %d", got, http.StatusTooManyRequests)
+	}
+	status = http.StatusOK
+	if got := get(); got != http.StatusOK {
+		t.Errorf("second status = %d, want %d", got, http.StatusOK)
+	}
+	// The success is recorded and replayed without another request
+	status = http.StatusInternalServerError
+	if got := get(); got != http.StatusOK {
+		t.Errorf("replayed status = %d, want %d", got, http.StatusOK)
+	}
+	if requests != 2 {
+		t.Errorf("server saw %d requests, want 2", requests)
+	}
+}
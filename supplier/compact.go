@@ -0,0 +1,251 @@
+package supplier
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// compactRunSize is how many results compactJournal sorts in memory at a time. Larger sets
+// are sorted in runs spilled to temporary files and merged back together, so compacting
+// needs memory for one run however many results have been saved.
+var compactRunSize = 50000
+
+// journalFile returns the file results are appended to as they complete, until they are
+// folded into output by compactJournal
+func journalFile(output string) string {
+	return output + ".journal"
+}
+
+// compactJournal folds the results appended to output's journal into the saved results,
+// replacing any older result for the same postcode, then removes the journal. It does
+// nothing when there is no journal.
+func compactJournal(format, output string) error {
+	journal := journalFile(output)
+	info, err := os.Stat(journal)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading results journal: %v", err)
+	}
+
+	if info.Size() > 0 {
+		existing, err := existingResultsFile(format, output)
+		if err != nil {
+			return err
+		}
+
+		runs := &sortedRuns{dir: filepath.Dir(output)}
+		defer runs.remove()
+
+		// The journal is added last so its results replace the saved ones
+		if existing != "" {
+			if err := streamResultsFile(existing)(runs.add); err != nil {
+				return err
+			}
+		}
+		if err := streamNDJSONResults(journal)(runs.add); err != nil {
+			return err
+		}
+		if err := saveSortedResults(runs.merge, format, output); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Remove(journal); err != nil {
+		return fmt.Errorf("error removing results journal: %v", err)
+	}
+	return nil
+}
+
+// sortedRuns sorts results by postcode in runs of compactRunSize, spilling each full run to
+// a temporary NDJSON file, and merges the runs back into one stream keeping only the result
+// added last for each postcode
+type sortedRuns struct {
+	dir   string           // Directory the run files are written to
+	files []string         // Run files spilled so far, in the order they were added
+	batch []PostcodeResult // Results added since the last spill
+}
+
+// add adds a result, spilling the current run to disk once it is full
+func (r *sortedRuns) add(result PostcodeResult) error {
+	r.batch = append(r.batch, result)
+	if len(r.batch) < compactRunSize {
+		return nil
+	}
+
+	file, err := os.CreateTemp(r.dir, ".compact-*.ndjson")
+	if err != nil {
+		return fmt.Errorf("error creating compaction run: %v", err)
+	}
+	r.files = append(r.files, file.Name())
+
+	buf := bufio.NewWriter(file)
+	encoder := json.NewEncoder(buf)
+	for _, result := range sortRun(r.batch) {
+		if err := encoder.Encode(result); err != nil {
+			file.Close()
+			return fmt.Errorf("error writing compaction run: %v", err)
+		}
+	}
+	if err := buf.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing compaction run: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error writing compaction run: %v", err)
+	}
+
+	r.batch = r.batch[:0]
+	return nil
+}
+
+// merge streams the results of every run in postcode order, with only the result added last
+// for each postcode. It may be called more than once.
+func (r *sortedRuns) merge(fn func(PostcodeResult) error) error {
+	// The results not yet spilled make up the last run
+	r.batch = sortRun(r.batch)
+
+	var cursors runHeap
+	for i, filename := range r.files {
+		file, err := os.Open(filename)
+		if err != nil {
+			return fmt.Errorf("error opening compaction run: %v", err)
+		}
+		defer file.Close()
+
+		decoder := json.NewDecoder(bufio.NewReader(file))
+		cursors = append(cursors, &runCursor{order: i, next: func() (PostcodeResult, bool, error) {
+			var result PostcodeResult
+			err := decoder.Decode(&result)
+			if err == io.EOF {
+				return result, false, nil
+			}
+			if err != nil {
+				return result, false, fmt.Errorf("error reading compaction run: %v", err)
+			}
+			return result, true, nil
+		}})
+	}
+	batch := r.batch
+	cursors = append(cursors, &runCursor{order: len(r.files), next: func() (PostcodeResult, bool, error) {
+		if len(batch) == 0 {
+			return PostcodeResult{}, false, nil
+		}
+		result := batch[0]
+		batch = batch[1:]
+		return result, true, nil
+	}})
+
+	// Drop runs that turn out to be empty, then order the rest by their first result
+	live := cursors[:0]
+	for _, cursor := range cursors {
+		ok, err := cursor.advance()
+		if err != nil {
+			return err
+		}
+		if ok {
+			live = append(live, cursor)
+		}
+	}
+	cursors = live
+	heap.Init(&cursors)
+
+	var last string
+	emitted := false
+	for cursors.Len() > 0 {
+		cursor := cursors[0]
+		result := cursor.result
+
+		ok, err := cursor.advance()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&cursors, 0)
+		} else {
+			heap.Pop(&cursors)
+		}
+
+		// The latest run's result for a postcode comes out first, so later ones are older
+		if emitted && result.Postcode == last {
+			continue
+		}
+		if err := fn(result); err != nil {
+			return err
+		}
+		last, emitted = result.Postcode, true
+	}
+	return nil
+}
+
+// remove deletes the spilled run files
+func (r *sortedRuns) remove() {
+	for _, filename := range r.files {
+		os.Remove(filename)
+	}
+}
+
+// sortRun sorts results by postcode in place, keeping only the last of each postcode's
+// results, and returns the shortened slice
+func sortRun(results []PostcodeResult) []PostcodeResult {
+	slices.SortStableFunc(results, func(a, b PostcodeResult) int {
+		return strings.Compare(a.Postcode, b.Postcode)
+	})
+
+	kept := results[:0]
+	for i, result := range results {
+		if i+1 < len(results) && results[i+1].Postcode == result.Postcode {
+			continue
+		}
+		kept = append(kept, result)
+	}
+	return kept
+}
+
+// runCursor is the next unmerged result of a sorted run
+type runCursor struct {
+	order  int // Position of the run, later runs replacing earlier ones
+	result PostcodeResult
+	next   func() (PostcodeResult, bool, error)
+}
+
+// advance moves the cursor on to the run's next result, reporting false at the end of it
+func (c *runCursor) advance() (bool, error) {
+	result, ok, err := c.next()
+	if err != nil || !ok {
+		return false, err
+	}
+	c.result = result
+	return true, nil
+}
+
+// runHeap orders run cursors by postcode, and the latest run first for the same postcode
+type runHeap []*runCursor
+
+func (h runHeap) Len() int { return len(h) }
+
+func (h runHeap) Less(i, j int) bool {
+	if h[i].result.Postcode != h[j].result.Postcode {
+		return h[i].result.Postcode < h[j].result.Postcode
+	}
+	return h[i].order > h[j].order
+}
+
+func (h runHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *runHeap) Push(x any) { *h = append(*h, x.(*runCursor)) }
+
+func (h *runHeap) Pop() any {
+	old := *h
+	cursor := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return cursor
+}
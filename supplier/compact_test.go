@@ -0,0 +1,189 @@
+package supplier
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writeJournal appends results to output's journal the way a run does
+func writeJournal(t testing.TB, output string, results []PostcodeResult) {
+	t.Helper()
+	w, err := openNDJSON(journalFile(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if err := w.Write(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompactJournal(t *testing.T) {
+	defer func(size int) { compactRunSize = size }(compactRunSize)
+	// Small runs, so compaction spills and merges several of them
+	compactRunSize = 2
+
+	fetchedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	result := func(postcode, supplier string) PostcodeResult {
+		return PostcodeResult{Postcode: postcode, Supplier: supplier, Status: StatusFound, FetchedAt: fetchedAt}
+	}
+	saved := []PostcodeResult{result("B33 8TH", "Severn Trent"), result("M1 1AE", "United Utilities"), result("SW1A 1AA", "Thames Water")}
+	journaled := []PostcodeResult{
+		result("ZE3 9JZ", "Scottish Water"), result("M1 1AE", "United Utilities Water"), result("AB1 0AA", "Scottish Water"),
+		result("ZE3 9JZ", "Scottish Water Business"), result("HP1 1BB", "Affinity Water"),
+	}
+	want := []PostcodeResult{
+		result("AB1 0AA", "Scottish Water"), result("B33 8TH", "Severn Trent"), result("HP1 1BB", "Affinity Water"),
+		result("M1 1AE", "United Utilities Water"), result("SW1A 1AA", "Thames Water"), result("ZE3 9JZ", "Scottish Water Business"),
+	}
+
+	tests := []struct {
+		format string
+		output string
+	}{
+		{"json", "out.json"},
+		{"json", "out.json.gz"},
+		{"csv", "out.json"},
+		{"both", "out.json"},
+	}
+	for _, tt := range tests {
+		format := tt.format
+		t.Run(format+" "+tt.output, func(t *testing.T) {
+			dir := t.TempDir()
+			output := filepath.Join(dir, tt.output)
+			if err := saveResults(saved, format, output); err != nil {
+				t.Fatalf("saveResults() error = %v", err)
+			}
+			writeJournal(t, output, journaled)
+
+			if err := compactJournal(format, output); err != nil {
+				t.Fatalf("compactJournal() error = %v", err)
+			}
+
+			got, err := loadExistingResults(format, output)
+			if err != nil {
+				t.Fatalf("loadExistingResults() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("compacted results =\n%+v\nwant\n%+v", got, want)
+			}
+			if format == "both" {
+				csvResults, err := LoadResultsFile(csvResultsFile(output))
+				if err != nil {
+					t.Fatalf("LoadResultsFile(csv) error = %v", err)
+				}
+				if !reflect.DeepEqual(csvResults, want) {
+					t.Errorf("compacted CSV results =\n%+v\nwant\n%+v", csvResults, want)
+				}
+			}
+
+			// Only the results are left, without the journal or any run files
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range entries {
+				if name := entry.Name(); name != tt.output && name != "out.csv" {
+					t.Errorf("compactJournal() left %s behind", name)
+				}
+			}
+		})
+	}
+}
+
+func TestCompactJournalWithoutJournal(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "out.json")
+	if err := compactJournal("json", output); err != nil {
+		t.Fatalf("compactJournal() error = %v", err)
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("compactJournal() without a journal wrote %s", output)
+	}
+}
+
+// BenchmarkCompactJournal compacts a journal into a results file many times the run size,
+// reporting the peak heap in use, which stays around one run's worth of results whatever
+// the number of results
+func BenchmarkCompactJournal(b *testing.B) {
+	for _, count := range []int{10000, 100000} {
+		b.Run(fmt.Sprint(count), func(b *testing.B) {
+			defer func(size int) { compactRunSize = size }(compactRunSize)
+			compactRunSize = 5000
+
+			// Results are generated as they are written, so the benchmark holds none of them
+			generate := func(from, to int) resultStream {
+				return func(fn func(PostcodeResult) error) error {
+					for i := from; i < to; i++ {
+						err := fn(PostcodeResult{
+							Postcode: fmt.Sprintf("AB%d %dXY", i%997, i), Supplier: "Thames Water", Phone: "0800 316 9800",
+							Link: "https://www.thameswater.co.uk", Status: StatusFound, FetchedAt: time.Now().UTC(),
+						})
+						if err != nil {
+							return err
+						}
+					}
+					return nil
+				}
+			}
+			output := filepath.Join(b.TempDir(), "out.json")
+			if err := saveResultsToJSON(generate(0, count/2), output); err != nil {
+				b.Fatal(err)
+			}
+
+			var peak atomic.Uint64
+			b.ResetTimer()
+			for range b.N {
+				b.StopTimer()
+				w, err := openNDJSON(journalFile(output))
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := generate(count/2, count)(w.Write); err != nil {
+					b.Fatal(err)
+				}
+				if err := w.Close(); err != nil {
+					b.Fatal(err)
+				}
+				runtime.GC()
+				b.StartTimer()
+
+				// Sample the heap while compacting
+				done := make(chan struct{})
+				var wg sync.WaitGroup
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					var stats runtime.MemStats
+					for {
+						runtime.ReadMemStats(&stats)
+						if stats.HeapInuse > peak.Load() {
+							peak.Store(stats.HeapInuse)
+						}
+						select {
+						case <-done:
+							return
+						case <-time.After(time.Millisecond):
+						}
+					}
+				}()
+				if err := compactJournal("json", output); err != nil {
+					b.Fatal(err)
+				}
+				close(done)
+				wg.Wait()
+			}
+			b.ReportMetric(float64(peak.Load())/(1<<20), "peak-heap-MB")
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package supplier
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// DefaultEnrichConcurrency is the number of supplier sites fetched at once when enriching
+	DefaultEnrichConcurrency = 2
+
+	// maxEnrichBody caps how much of a supplier's page is read looking for its title
+	maxEnrichBody = 1 << 20
+)
+
+// linkEnricher follows the supplier link of found results to add details from the
+// supplier's own site. Each link is fetched once per run, as most postcodes share a handful
+// of suppliers, and dead links are remembered so they aren't tried again. It is safe for
+// concurrent use.
+type linkEnricher struct {
+	fetcher *Fetcher
+	slots   chan struct{} // Bounds the enrichment fetches in flight
+
+	mu     sync.Mutex
+	titles map[string]string // Page title by link, empty for dead links
+	group  singleflight.Group
+}
+
+// newLinkEnricher creates an enricher fetching through fetcher's client, at most
+// concurrency links at once
+func newLinkEnricher(fetcher *Fetcher, concurrency int) *linkEnricher {
+	return &linkEnricher{
+		fetcher: fetcher,
+		slots:   make(chan struct{}, concurrency),
+		titles:  make(map[string]string),
+	}
+}
+
+// enrich returns result with the title of its supplier's page filled in. Results without a
+// supplier link, and links that can't be fetched, are returned unchanged.
+func (e *linkEnricher) enrich(ctx context.Context, result PostcodeResult) PostcodeResult {
+	if result.Status != StatusFound || result.Link == "" {
+		return result
+	}
+
+	e.mu.Lock()
+	title, ok := e.titles[result.Link]
+	e.mu.Unlock()
+
+	if !ok {
+		v, _, _ := e.group.Do(result.Link, func() (any, error) {
+			title, err := e.fetchTitle(ctx, result.Link)
+			if err != nil {
+				// Cancellation says nothing about the link, so it is tried again next time
+				if ctx.Err() != nil {
+					return "", nil
+				}
+				slog.Warn("Error enriching supplier link", "link", result.Link, "err", err)
+			}
+			e.mu.Lock()
+			e.titles[result.Link] = title
+			e.mu.Unlock()
+			return title, nil
+		})
+		title = v.(string)
+	}
+
+	result.LinkTitle = title
+	return result
+}
+
+// fetchTitle fetches the page at link and returns its title
+func (e *linkEnricher) fetchTitle(ctx context.Context, link string) (string, error) {
+	select {
+	case e.slots <- struct{}{}:
+		defer func() { <-e.slots }()
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+
+	ctx, cancel := e.fetcher.requestContext(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", link, nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating supplier page request: %v", err)
+	}
+	req.Header.Set("User-Agent", e.fetcher.userAgent())
+
+	resp, err := e.fetcher.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error fetching supplier page: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("received non-OK HTTP status for supplier page: %s", resp.Status)
+	}
+
+	doc, err := goquery.NewDocumentFromReader(io.LimitReader(resp.Body, maxEnrichBody))
+	if err != nil {
+		return "", fmt.Errorf("error parsing supplier page: %v", err)
+	}
+	return strings.Join(strings.Fields(doc.Find("title").First().Text()), " "), nil
+}
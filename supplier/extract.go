@@ -0,0 +1,233 @@
+package supplier
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"os"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrNoSupplier is returned by an Extractor when the markup holds no supplier at all, as
+// opposed to supplier markup it couldn't parse
+var ErrNoSupplier = errors.New("no supplier in markup")
+
+// Extractor parses the supplier details out of the markup returned for a postcode lookup.
+// The result needs only its supplier fields filled in; the fetcher adds the rest.
+type Extractor interface {
+	Extract(html string) (PostcodeResult, error)
+}
+
+// NewExtractor returns the extractor called name, configured with selectors where it uses
+// them. The only extractor so far is "wateruk".
+func NewExtractor(name string, selectors Selectors) (Extractor, error) {
+	switch name {
+	case "wateruk":
+		return WaterUKExtractor{Selectors: selectors}, nil
+	default:
+		return nil, fmt.Errorf("unknown extractor %q: must be wateruk", name)
+	}
+}
+
+// WaterUKExtractor extracts suppliers from the Water UK find-your-supplier markup
+type WaterUKExtractor struct {
+	Selectors Selectors
+}
+
+// Extract returns the water supplier, and the sewerage supplier when listed separately
+func (e WaterUKExtractor) Extract(body string) (PostcodeResult, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(body))
+	if err != nil {
+		return PostcodeResult{}, fmt.Errorf("error parsing supplier markup: %v", err)
+	}
+	if doc.Find(e.Selectors.Name).Length() == 0 {
+		return PostcodeResult{}, ErrNoSupplier
+	}
+
+	supplier := extractSupplierDetails(doc, e.Selectors)
+	if supplier["name"] == "Not Found" {
+		return PostcodeResult{}, fmt.Errorf("supplier name not found in markup")
+	}
+	return PostcodeResult{
+		Supplier:         supplier["name"],
+		Phone:            supplier["phone"],
+		Link:             supplier["link"],
+		Email:            supplier["email"],
+		Address:          supplier["address"],
+		SewerageSupplier: supplier["sewerage_name"],
+		SeweragePhone:    supplier["sewerage_phone"],
+		SewerageLink:     supplier["sewerage_link"],
+		SewerageEmail:    supplier["sewerage_email"],
+		SewerageAddress:  supplier["sewerage_address"],
+	}, nil
+}
+
+// Selectors are the CSS selectors locating each supplier field in the lookup response, so a
+// change to the site's markup can be handled without a rebuild
+type Selectors struct {
+	Name    string `yaml:"name"`    // Supplier name heading, one per supplier block
+	Block   string `yaml:"block"`   // Element containing a supplier's fields, searched up from the name
+	Phone   string `yaml:"phone"`   // Phone number within the block
+	Link    string `yaml:"link"`    // Website link within the block, read from its href
+	Email   string `yaml:"email"`   // Email address within the block, before falling back to a mailto: link
+	Address string `yaml:"address"` // Postal address within the block
+}
+
+// DefaultSelectors returns the selectors matching the Water UK supplier markup
+func DefaultSelectors() Selectors {
+	return Selectors{
+		Name:    ".supplier__name",
+		Block:   ".supplier",
+		Phone:   ".supplier__phone b",
+		Link:    "a.supplier__link",
+		Email:   ".supplier__email",
+		Address: ".supplier__address",
+	}
+}
+
+// LoadSelectors reads selectors from a YAML or JSON file keyed by field, with any field
+// left out keeping its default
+func LoadSelectors(path string) (Selectors, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Selectors{}, fmt.Errorf("error reading selectors file: %v", err)
+	}
+
+	// JSON is valid YAML, so one decoder handles both formats
+	selectors := DefaultSelectors()
+	if err := yaml.Unmarshal(data, &selectors); err != nil {
+		return Selectors{}, fmt.Errorf("error parsing selectors file: %v", err)
+	}
+	if err := selectors.Validate(); err != nil {
+		return Selectors{}, err
+	}
+	return selectors, nil
+}
+
+// Validate checks every selector is set and parses, as goquery silently matches nothing
+// for an invalid one
+func (s Selectors) Validate() error {
+	fields := []struct{ name, selector string }{
+		{"name", s.Name},
+		{"block", s.Block},
+		{"phone", s.Phone},
+		{"link", s.Link},
+		{"email", s.Email},
+		{"address", s.Address},
+	}
+	for _, field := range fields {
+		if field.selector == "" {
+			return fmt.Errorf("invalid %s selector: must not be empty", field.name)
+		}
+		if _, err := cascadia.ParseGroup(field.selector); err != nil {
+			return fmt.Errorf("invalid %s selector %q: %v", field.name, field.selector, err)
+		}
+	}
+	return nil
+}
+
+// extractSupplierDetails extracts the supplier name, phone, link, email, and address from
+// the parsed response markup. When a second supplier block is present (waste water), its details are returned
+// under the same keys prefixed with sewerage_.
+func extractSupplierDetails(doc *goquery.Document, selectors Selectors) map[string]string {
+	details := map[string]string{
+		"name":  "Not Found",
+		"phone": "Not Found",
+		"link":  "Not Found",
+	}
+
+	// Each supplier name heading marks a separate supplier block
+	doc.Find(selectors.Name).EachWithBreak(func(i int, heading *goquery.Selection) bool {
+		fields := supplierFields(heading, selectors)
+		switch i {
+		case 0:
+			for key, value := range fields {
+				if value != "" {
+					details[key] = value
+				}
+			}
+			return true
+		default:
+			for key, value := range fields {
+				details["sewerage_"+key] = value
+			}
+			return false
+		}
+	})
+
+	return details
+}
+
+// supplierFields returns the name, phone, link, email, and address of the supplier block
+// containing heading, keyed by field; fields missing from the block are empty
+func supplierFields(heading *goquery.Selection, selectors Selectors) map[string]string {
+	// Select the fields by class so extra attributes or reordering don't matter
+	block := heading.Closest(selectors.Block)
+	if block.Length() == 0 {
+		block = heading.Parent()
+	}
+
+	link, _ := block.Find(selectors.Link).First().Attr("href")
+	return map[string]string{
+		"name":    cleanText(heading.Text()),
+		"phone":   normalizePhone(cleanText(block.Find(selectors.Phone).First().Text())),
+		"link":    unescapeLink(link),
+		"email":   supplierEmail(block, selectors.Email),
+		"address": addressText(block.Find(selectors.Address).First()),
+	}
+}
+
+// addressText flattens a multi-line address element into a single line, joining each line
+// of text (split by <br> or nested elements) with ", "
+func addressText(address *goquery.Selection) string {
+	var lines []string
+	var walk func(s *goquery.Selection)
+	walk = func(s *goquery.Selection) {
+		s.Contents().Each(func(_ int, child *goquery.Selection) {
+			if goquery.NodeName(child) != "#text" {
+				walk(child)
+				return
+			}
+			// Lines often carry their own trailing comma, so avoid doubling it up
+			if line := strings.Trim(cleanText(child.Text()), ", "); line != "" {
+				lines = append(lines, line)
+			}
+		})
+	}
+	walk(address)
+	return strings.Join(lines, ", ")
+}
+
+// supplierEmail returns the contact email of a supplier block, taken from the element
+// matching selector or failing that a mailto: link, or empty if there is neither
+func supplierEmail(block *goquery.Selection, selector string) string {
+	if email := cleanText(block.Find(selector).First().Text()); email != "" {
+		return email
+	}
+
+	href, ok := block.Find(`a[href^="mailto:"]`).First().Attr("href")
+	if !ok {
+		return ""
+	}
+	// Drop any ?subject= style parameters after the address
+	email, _, _ := strings.Cut(strings.TrimPrefix(href, "mailto:"), "?")
+	return strings.TrimSpace(email)
+}
+
+// cleanText returns the plain text of a field: nested markup is already dropped by Text(),
+// runs of whitespace left between inner elements are collapsed to single spaces, and
+// entities left after parsing are decoded. The parser already decodes one level, so anything
+// remaining was double-escaped in the markup (e.g. "&amp;amp;").
+func cleanText(text string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(text)), " ")
+}
+
+// unescapeLink decodes a double-escaped "&amp;" in a link's query string. Only that entity
+// is decoded, as a full unescape would turn parameters like "&copy=1" into "©=1".
+func unescapeLink(link string) string {
+	return strings.ReplaceAll(strings.TrimSpace(link), "&amp;", "&")
+}
@@ -0,0 +1,47 @@
+package supplier
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func FuzzExtractSupplierDetails(f *testing.F) {
+	// Seed with the supplier markup of the recorded responses, and truncations of it
+	for _, body := range ajaxFixtures(f) {
+		commands, err := parseAjaxResponse(body)
+		if err != nil {
+			f.Fatal(err)
+		}
+		for _, command := range commands {
+			if strings.Contains(command.Data, "<") {
+				f.Add(command.Data)
+				f.Add(command.Data[:len(command.Data)/2])
+			}
+		}
+	}
+	f.Add("")
+	f.Add(`<div class="supplier"><h3 class="supplier__name">`)
+
+	selectors := DefaultSelectors()
+	extractor := WaterUKExtractor{Selectors: selectors}
+	f.Fuzz(func(t *testing.T, markup string) {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(markup))
+		if err != nil {
+			t.Skip()
+		}
+		details := extractSupplierDetails(doc, selectors)
+		for _, key := range []string{"name", "phone", "link"} {
+			if details[key] == "" {
+				t.Errorf("extractSupplierDetails(%q)[%q] is empty, want a value or Not Found", markup, key)
+			}
+		}
+
+		// A successful extraction always names the supplier
+		result, err := extractor.Extract(markup)
+		if err == nil && (result.Supplier == "" || result.Supplier == "Not Found") {
+			t.Errorf("Extract(%q) supplier = %q, want a name", markup, result.Supplier)
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package supplier
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWaterUKExtractorExtract(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want PostcodeResult
+	}{
+		{
+			name: "single supplier",
+			html: `<div class="supplier"><h3 class="supplier__name">Thames Water</h3>` +
+				`<p class="supplier__phone"><b>0800 316 9800</b></p>` +
+				`<a class="supplier__link" href="https://www.thameswater.co.uk">Visit</a></div>`,
+			want: PostcodeResult{Supplier: "Thames Water", Phone: "0800 316 9800", Link: "https://www.thameswater.co.uk"},
+		},
+		{
+			name: "extra attributes and reordered fields",
+			html: `<div id="s1" class="supplier supplier--water" data-x="1">` +
+				`<a data-track="link" href="https://www.thameswater.co.uk" class="btn supplier__link" target="_blank">Visit</a>` +
+				`<p class="supplier__phone small"><b class="num">0800 316 9800</b></p>` +
+				`<h3 class="heading supplier__name" id="name">Thames Water</h3></div>`,
+			want: PostcodeResult{Supplier: "Thames Water", Phone: "0800 316 9800", Link: "https://www.thameswater.co.uk"},
+		},
+		{
+			name: "nested markup in name",
+			html: `<div class="supplier"><h3 class="supplier__name">Thames <span class="x">Water</span>
+				</h3><p class="supplier__phone"><b>0800 316 9800</b></p></div>`,
+			want: PostcodeResult{Supplier: "Thames Water", Phone: "0800 316 9800", Link: "Not Found"},
+		},
+		{
+			name: "entities",
+			html: `<div class="supplier"><h3 class="supplier__name">Bristol Water &amp;amp; Sewerage</h3>` +
+				`<a class="supplier__link" href="https://example.com/?a=1&amp;amp;b=2">Visit</a></div>`,
+			want: PostcodeResult{Supplier: "Bristol Water & Sewerage", Phone: "Not Found", Link: "https://example.com/?a=1&b=2"},
+		},
+		{
+			name: "water and sewerage suppliers",
+			html: `<div class="supplier"><h3 class="supplier__name">Affinity Water</h3>` +
+				`<p class="supplier__phone"><b>0345 357 2407</b></p></div>` +
+				`<div class="supplier"><h3 class="supplier__name">Thames Water</h3>` +
+				`<p class="supplier__phone"><b>0800-316-9800</b></p>` +
+				`<a class="supplier__link" href="https://www.thameswater.co.uk">Visit</a></div>`,
+			want: PostcodeResult{
+				Supplier: "Affinity Water", Phone: "0345 357 2407", Link: "Not Found",
+				SewerageSupplier: "Thames Water", SeweragePhone: "0800 316 9800", SewerageLink: "https://www.thameswater.co.uk",
+			},
+		},
+		{
+			name: "mailto email and multi-line address",
+			html: `<div class="supplier"><h3 class="supplier__name">Wessex Water</h3>` +
+				`<a href="mailto:help@wessexwater.co.uk?subject=Hi">Email</a>` +
+				`<p class="supplier__address">Claverton Down Road,<br>Bath<br/>  BA2 7WW</p></div>`,
+			want: PostcodeResult{Supplier: "Wessex Water", Phone: "Not Found", Link: "Not Found", Email: "help@wessexwater.co.uk", Address: "Claverton Down Road, Bath, BA2 7WW"},
+		},
+	}
+
+	extractor := WaterUKExtractor{Selectors: DefaultSelectors()}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := extractor.Extract(tt.html)
+			if err != nil {
+				t.Fatalf("Extract() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Extract() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWaterUKExtractorNoSupplier(t *testing.T) {
+	extractor := WaterUKExtractor{Selectors: DefaultSelectors()}
+	for _, html := range []string{"", "<p>No supplier found for this postcode</p>", "<div class=\"supplier\">"} {
+		if _, err := extractor.Extract(html); !errors.Is(err, ErrNoSupplier) {
+			t.Errorf("Extract(%q) error = %v, want ErrNoSupplier", html, err)
+		}
+	}
+}
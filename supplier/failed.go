@@ -0,0 +1,74 @@
+package supplier
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+const (
+	// FailedPostcodesFile is the default file recording postcodes that exhausted their retries
+	FailedPostcodesFile = "failed_postcodes.json"
+
+	// DeadLetterFile is the default file recording postcodes that failed again in a retry
+	// pass, which are never attempted again unless reset
+	DeadLetterFile = "dead_letter.json"
+)
+
+// FailedPostcode records a postcode whose lookup failed and why
+type FailedPostcode struct {
+	Postcode   string `json:"postcode"`
+	Error      string `json:"error"`
+	HTTPStatus int    `json:"http_status,omitempty"`
+	Attempts   int    `json:"attempts,omitempty"`
+}
+
+// loadFailedPostcodes loads a failed postcodes file, such as the failed postcodes or the
+// dead letter, keyed by postcode
+func loadFailedPostcodes(filename string) (map[string]FailedPostcode, error) {
+	failed := make(map[string]FailedPostcode)
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return failed, nil
+		}
+		return nil, fmt.Errorf("error reading %s: %v", filename, err)
+	}
+
+	var entries []FailedPostcode
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", filename, err)
+	}
+	for _, entry := range entries {
+		failed[entry.Postcode] = entry
+	}
+
+	return failed, nil
+}
+
+// saveFailedPostcodes writes the failed postcodes, sorted by postcode, to filename
+func saveFailedPostcodes(failed map[string]FailedPostcode, filename string) error {
+	entries := make([]FailedPostcode, 0, len(failed))
+	for _, entry := range failed {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Postcode < entries[j].Postcode })
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling failed postcodes: %v", err)
+	}
+
+	err = writeFileAtomic(filename, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error writing %s: %v", filename, err)
+	}
+
+	return nil
+}
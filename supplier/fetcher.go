@@ -0,0 +1,745 @@
+package supplier
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"mime"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/sync/singleflight"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// DefaultFormURL is the find-your-supplier page hosting the lookup form
+	DefaultFormURL = "https://www.water.org.uk/customers/find-your-supplier"
+
+	// DefaultEndpointURL is the find-your-supplier AJAX form endpoint
+	DefaultEndpointURL = DefaultFormURL + "?ajax_form=1&_wrapper_format=drupal_ajax"
+
+	// DefaultFormID is the Drupal form_id of the supplier lookup form
+	DefaultFormID = "wateruk_find_my_supplier"
+
+	// Defaults for retrying failed lookups and bounding each request
+	DefaultRetries       = 3
+	DefaultRetryDelay    = 1 * time.Second
+	DefaultMaxRetryDelay = 30 * time.Second
+	DefaultTimeout       = 30 * time.Second
+)
+
+// Fetcher looks up the water supplier for postcodes using the given HTTP client and endpoint
+type Fetcher struct {
+	Client   *http.Client
+	Endpoint string          // URL the lookup form is submitted to
+	FormURL  string          // Page the form_build_id token is scraped from
+	FormID   string          // Drupal form_id submitted with each lookup
+	Limiter  *rate.Limiter   // Shared request rate limit, nil for unlimited
+	Breaker  *CircuitBreaker // Pauses requests during sustained failures, nil to disable
+	Timeout  time.Duration   // Per-request deadline, zero for none
+
+	// Failed lookups are attempted up to Retries times in all, backing off exponentially
+	// from RetryDelay up to MaxRetryDelay between attempts. MaxRetryDelay also caps how
+	// long a Retry-After header can hold a lookup.
+	Retries       int
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+
+	// Cache answers postcodes already looked up in the run without another request, nil to
+	// disable; duplicates in flight at the same time share a single lookup
+	Cache    *ResultCache
+	inFlight singleflight.Group
+
+	Metrics *Metrics // Counts requests and retries, nil to not count them
+
+	DebugDir  string    // Directory raw responses are saved to when parsing misses, empty to disable
+	Extractor Extractor // Parses the supplier details out of the response markup
+
+	// Headers are sent with each lookup on top of the Content-Type, User-Agent, and a Referer
+	// of the form page, overriding any of them. A header with an empty value is removed.
+	Headers http.Header
+
+	// UserAgents are rotated round-robin across requests, defaulting to defaultUserAgents
+	UserAgents []string
+	nextAgent  atomic.Uint64
+
+	requests atomic.Int64 // HTTP requests issued, for the run summary
+
+	mu          sync.Mutex
+	formBuildID string // Cached Drupal form token, shared by all requests in the run
+}
+
+// defaultUserAgents is a small set of common desktop browser User-Agent strings
+var defaultUserAgents = []string{
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.6 Safari/605.1.15",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:131.0) Gecko/20100101 Firefox/131.0",
+	"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36 Edg/129.0.0.0",
+}
+
+// defaultHeaders returns the headers a browser sends when submitting the lookup form
+func defaultHeaders() http.Header {
+	return http.Header{
+		"Accept":           {"application/json, text/javascript, */*; q=0.01"},
+		"Accept-Language":  {"en-GB,en;q=0.9"},
+		"X-Requested-With": {"XMLHttpRequest"},
+	}
+}
+
+// NewFetcher returns a fetcher for the Water UK supplier lookup with the default settings,
+// which can be adjusted before its first use
+func NewFetcher() *Fetcher {
+	jar, _ := cookiejar.New(nil) // Never fails without options
+	return &Fetcher{
+		Client:        &http.Client{Timeout: DefaultTimeout, Jar: jar},
+		Endpoint:      DefaultEndpointURL,
+		FormURL:       DefaultFormURL,
+		FormID:        DefaultFormID,
+		Headers:       defaultHeaders(),
+		Extractor:     WaterUKExtractor{Selectors: DefaultSelectors()},
+		Retries:       DefaultRetries,
+		RetryDelay:    DefaultRetryDelay,
+		MaxRetryDelay: DefaultMaxRetryDelay,
+	}
+}
+
+// Lookup finds the supplier for a single postcode, retrying failures as configured. A
+// postcode the site has no supplier for is not an error: the result has StatusNotFound.
+// The error is non-nil when no definitive answer was obtained, with the result still
+// describing the last attempt.
+func (f *Fetcher) Lookup(ctx context.Context, postcode string) (PostcodeResult, error) {
+	normalized, ok := normalizePostcode(postcode)
+	if !ok {
+		return PostcodeResult{Postcode: postcode}, fmt.Errorf("invalid postcode %q", postcode)
+	}
+
+	result := f.getSupplierForPostcodeWithRetries(ctx, normalized)
+	if !result.Status.Definitive() {
+		return result, fmt.Errorf("error looking up postcode %s: %s", normalized, result.Error)
+	}
+	return result, nil
+}
+
+// WarmUp loads the form page to establish the session cookies and fetch the form token
+// before the first lookup, so lookups are submitted the way a browser would
+func (f *Fetcher) WarmUp(ctx context.Context) error {
+	_, err := f.getFormBuildID(ctx, "")
+	return err
+}
+
+// getSupplierForPostcodeWithRetries looks up postcode, answering from the cache when it
+// has already been looked up
+func (f *Fetcher) getSupplierForPostcodeWithRetries(ctx context.Context, postcode string) PostcodeResult {
+	if f.Cache == nil {
+		return f.lookupWithRetries(ctx, postcode)
+	}
+	if result, ok := f.Cache.get(postcode); ok {
+		slog.Debug("Using cached result", "postcode", postcode)
+		return result
+	}
+
+	v, _, _ := f.inFlight.Do(postcode, func() (any, error) {
+		result := f.lookupWithRetries(ctx, postcode)
+		if result.Status.Definitive() {
+			f.Cache.add(result)
+		}
+		return result, nil
+	})
+	return v.(PostcodeResult)
+}
+
+// lookupWithRetries performs the POST request with retries, backing off exponentially
+// between attempts. It gives up early, returning the last result, once ctx is cancelled.
+func (f *Fetcher) lookupWithRetries(ctx context.Context, postcode string) PostcodeResult {
+	retries := max(f.Retries, 1)
+	baseDelay, maxDelay := f.RetryDelay, max(f.MaxRetryDelay, f.RetryDelay)
+
+	result := PostcodeResult{Postcode: postcode, Status: StatusNetworkError, Error: "lookup cancelled"}
+
+	for i := 0; i < retries && ctx.Err() == nil; i++ {
+		if f.Breaker != nil {
+			if err := f.Breaker.Wait(ctx); err != nil {
+				break
+			}
+		}
+
+		if i > 0 {
+			f.Metrics.retried()
+		}
+		f.Metrics.attempting(1)
+		result = f.getSupplierForPostcode(ctx, postcode)
+		f.Metrics.attempting(-1)
+		result.Attempts = i + 1
+
+		// Attempts cut short by cancellation say nothing about the site's health
+		if f.Breaker != nil && ctx.Err() == nil {
+			f.Breaker.Record(result.Status.Definitive())
+		}
+
+		// Wait as long as the server asked, within maxDelay, before trying again when rate
+		// limited
+		if result.Status == StatusRateLimited {
+			slog.Debug("Rate limited", "postcode", postcode, "attempt", i+1)
+			if i < retries-1 {
+				delay := result.RetryAfter
+				if delay <= 0 {
+					delay = retryBackoff(i, baseDelay, maxDelay)
+				} else if delay > maxDelay {
+					slog.Debug("Capping Retry-After", "postcode", postcode, "retry_after", delay, "max", maxDelay)
+					delay = maxDelay
+				}
+				if sleepContext(ctx, delay) != nil {
+					break
+				}
+			}
+			continue
+		}
+
+		// A found supplier or a clean not-found is definitive, so accept it first time
+		switch result.Status {
+		case StatusFound:
+			slog.Debug("Supplier found", "postcode", postcode, "attempt", i+1, "supplier", result.Supplier)
+			return result
+		case StatusNotFound:
+			slog.Debug("No supplier for postcode", "postcode", postcode, "attempt", i+1)
+			return result
+		}
+
+		// Errors that won't go away on their own, such as client errors, aren't retried
+		if !retryable(result) {
+			slog.Warn("Lookup failed, not retrying", "postcode", postcode, "attempt", i+1, "status", result.Status, "err", result.Error)
+			return result
+		}
+
+		// Log the attempt and result
+		slog.Debug("Lookup failed", "postcode", postcode, "attempt", i+1, "status", result.Status, "err", result.Error)
+
+		// Wait before retrying
+		if i < retries-1 && sleepContext(ctx, retryBackoff(i, baseDelay, maxDelay)) != nil {
+			break
+		}
+	}
+
+	if ctx.Err() != nil {
+		slog.Debug("Lookup cancelled", "postcode", postcode)
+		return result
+	}
+	slog.Warn("All attempts failed", "postcode", postcode, "attempts", retries, "status", result.Status, "err", result.Error)
+	return result
+}
+
+// retryable reports whether a failed lookup may succeed if tried again: network errors
+// and server errors, unparseable responses, and rate limiting are; other HTTP statuses
+// (client errors the token refresh didn't fix) are not
+func retryable(result PostcodeResult) bool {
+	switch result.Status {
+	case StatusRateLimited, StatusParseError:
+		return true
+	case StatusNetworkError:
+		return result.HTTPStatus == 0 || result.HTTPStatus >= 500
+	default:
+		return false
+	}
+}
+
+// sleepContext waits for d, returning early with the context's error if ctx is cancelled
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryBackoff returns the delay before the retry following the given zero-based attempt.
+// The delay doubles with each attempt, is capped at maxDelay, and has up to 25% random
+// jitter added so concurrent workers don't retry in lockstep.
+func retryBackoff(attempt int, baseDelay, maxDelay time.Duration) time.Duration {
+	delay := baseDelay
+	for i := 0; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxDelay)
+
+	if jitter := delay / 4; jitter > 0 {
+		delay += rand.N(jitter)
+	}
+	return delay
+}
+
+// ClientOptions configures the shared HTTP client built by NewHTTPClient
+type ClientOptions struct {
+	ProxyURL    string        // Explicit proxy (http, https, socks5, or socks5h), overriding the environment
+	Timeout     time.Duration // Overall timeout per request, zero for none
+	Concurrency int           // Number of workers sharing the client, used to size the idle pool
+
+	// InsecureSkipVerify disables TLS certificate verification, only for testing through an
+	// intercepting proxy
+	InsecureSkipVerify bool
+
+	// CACertFile is a PEM file of extra CA certificates to trust, such as a corporate proxy's,
+	// on top of the system pool rather than instead of it
+	CACertFile string
+
+	// DisableKeepAlives opens a fresh connection for every request, for proxies that
+	// silently drop reused connections
+	DisableKeepAlives bool
+}
+
+// NewHTTPClient builds the single HTTP client shared by every lookup. All requests hit the
+// same host, so the transport keeps one idle keep-alive connection per worker to avoid
+// repeating TCP and TLS handshakes. A cookie jar keeps the session the form page sets for
+// every later submission. Requests go through the explicit proxy when set, otherwise
+// through HTTP_PROXY/HTTPS_PROXY if present.
+func NewHTTPClient(opts ClientOptions) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	transport.MaxIdleConns = max(opts.Concurrency, 100)
+	transport.MaxIdleConnsPerHost = max(opts.Concurrency, 2)
+	transport.IdleConnTimeout = 90 * time.Second
+	transport.DisableKeepAlives = opts.DisableKeepAlives
+
+	if opts.ProxyURL != "" {
+		u, err := url.Parse(opts.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %v", err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: opts.InsecureSkipVerify}
+	if opts.CACertFile != "" {
+		pool, err := loadCACerts(opts.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cookie jar: %v", err)
+	}
+
+	return &http.Client{Transport: transport, Timeout: opts.Timeout, Jar: jar}, nil
+}
+
+// loadCACerts returns the system certificate pool with the PEM certificates in filename added
+func loadCACerts(filename string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA certificate file: %v", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		slog.Warn("Error loading system certificates, trusting only the CA certificate file", "err", err)
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", filename)
+	}
+	return pool, nil
+}
+
+// ProxyFor reports the proxy the client will use for target, or nil for a direct connection
+func ProxyFor(client *http.Client, target string) (*url.URL, error) {
+	next := client.Transport
+	if cassettes, ok := next.(*CassetteTransport); ok {
+		next = cassettes.Next
+	}
+	transport, ok := next.(*http.Transport)
+	if !ok || transport.Proxy == nil {
+		return nil, nil
+	}
+
+	req, err := http.NewRequest("GET", target, nil)
+	if err != nil {
+		return nil, err
+	}
+	return transport.Proxy(req)
+}
+
+// requestContext returns the context for a single request derived from ctx, bounded by the
+// fetcher's timeout
+func (f *Fetcher) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if f.Timeout > 0 {
+		return context.WithTimeout(ctx, f.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// Requests returns the number of lookup requests issued so far
+func (f *Fetcher) Requests() int64 {
+	return f.requests.Load()
+}
+
+// userAgent returns the next User-Agent in the rotation; safe for concurrent use
+func (f *Fetcher) userAgent() string {
+	agents := f.UserAgents
+	if len(agents) == 0 {
+		agents = defaultUserAgents
+	}
+	n := f.nextAgent.Add(1) - 1
+	return agents[n%uint64(len(agents))]
+}
+
+// LoadUserAgents reads newline-delimited User-Agent strings from a file,
+// ignoring blank lines and lines starting with #
+func LoadUserAgents(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading user agents file: %v", err)
+	}
+
+	var agents []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		agents = append(agents, line)
+	}
+
+	if len(agents) == 0 {
+		return nil, fmt.Errorf("no user agents found in %s", path)
+	}
+	return agents, nil
+}
+
+// getSupplierForPostcode performs the POST request to get the supplier info for a given postcode.
+// If the submission looks like it was rejected because the form token expired, the token
+// is refreshed and the request retried once.
+func (f *Fetcher) getSupplierForPostcode(ctx context.Context, postcode string) (result PostcodeResult) {
+	// Stamp every result, successful or not, with when the lookup completed
+	defer func() { result.FetchedAt = time.Now().UTC().Truncate(time.Second) }()
+
+	token, err := f.getFormBuildID(ctx, "")
+	if err != nil {
+		slog.Error("Error getting form token", "postcode", postcode, "err", err)
+		return PostcodeResult{Postcode: postcode, Status: StatusNetworkError, Error: err.Error()}
+	}
+
+	result, tokenRejected := f.submitPostcode(ctx, postcode, token)
+	if !tokenRejected {
+		return result
+	}
+
+	slog.Info("Form token looks stale, refreshing", "postcode", postcode)
+	token, err = f.getFormBuildID(ctx, token)
+	if err != nil {
+		slog.Error("Error refreshing form token", "postcode", postcode, "err", err)
+		return result
+	}
+
+	result, _ = f.submitPostcode(ctx, postcode, token)
+	return result
+}
+
+// getFormBuildID returns the cached form_build_id, fetching a fresh one if none is cached
+// or if the cached token is the stale one the caller saw rejected
+func (f *Fetcher) getFormBuildID(ctx context.Context, stale string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Another worker may already have replaced the stale token
+	if f.formBuildID != "" && f.formBuildID != stale {
+		return f.formBuildID, nil
+	}
+
+	token, err := f.fetchFormBuildID(ctx)
+	if err != nil {
+		return "", err
+	}
+	f.formBuildID = token
+	return token, nil
+}
+
+// fetchFormBuildID loads the form page and extracts the current form_build_id token
+func (f *Fetcher) fetchFormBuildID(ctx context.Context) (string, error) {
+	ctx, cancel := f.requestContext(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", f.FormURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating form page request: %v", err)
+	}
+	req.Header.Set("User-Agent", f.userAgent())
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error fetching form page: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("received non-OK HTTP status for form page: %s", resp.Status)
+	}
+
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("error parsing form page: %v", err)
+	}
+
+	// Prefer the token belonging to the supplier form in case the page has several forms
+	selector := fmt.Sprintf(`form:has(input[name="form_id"][value=%q]) input[name="form_build_id"]`, f.FormID)
+	token, ok := doc.Find(selector).First().Attr("value")
+	if !ok || token == "" {
+		token, ok = doc.Find(`input[name="form_build_id"]`).First().Attr("value")
+	}
+	if !ok || token == "" {
+		return "", fmt.Errorf("form_build_id not found on form page")
+	}
+
+	slog.Info("Fetched form token", "token", token)
+	return token, nil
+}
+
+// submitPostcode posts the lookup form for a postcode using the given form token.
+// tokenRejected reports whether the response suggests the token was no longer valid.
+func (f *Fetcher) submitPostcode(ctx context.Context, postcode, formBuildID string) (result PostcodeResult, tokenRejected bool) {
+	// Data payload for the POST request
+	formData := url.Values{
+		"postcode":                  {postcode},
+		"form_build_id":             {formBuildID},
+		"form_id":                   {f.FormID},
+		"_triggering_element_name":  {"op"},
+		"_triggering_element_value": {"Submit"},
+		"_drupal_ajax":              {"1"},
+	}
+
+	// Respect the global request rate shared by all workers
+	if f.Limiter != nil {
+		if err := f.Limiter.Wait(ctx); err != nil {
+			slog.Warn("Error waiting for rate limiter", "postcode", postcode, "err", err)
+			return PostcodeResult{Postcode: postcode, Status: StatusNetworkError, Error: fmt.Sprintf("error waiting for rate limiter: %v", err)}, false
+		}
+	}
+
+	slog.Debug("Sending request", "postcode", postcode)
+
+	// Create the POST request
+	ctx, cancel := f.requestContext(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", f.Endpoint, strings.NewReader(formData.Encode()))
+	if err != nil {
+		slog.Error("Error creating request", "postcode", postcode, "err", err)
+		return PostcodeResult{Postcode: postcode, Status: StatusNetworkError, Error: fmt.Sprintf("error creating request: %v", err)}, false
+	}
+
+	// Set the headers a browser submitting the form would send
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=UTF-8")
+	req.Header.Set("User-Agent", f.userAgent())
+	req.Header.Set("Referer", f.FormURL)
+	for name, values := range f.Headers {
+		if len(values) == 0 || values[0] == "" {
+			req.Header.Del(name)
+			continue
+		}
+		req.Header[name] = values
+	}
+
+	// Perform the POST request
+	f.requests.Add(1)
+	start := time.Now()
+	resp, err := f.Client.Do(req)
+	f.Metrics.requestDone(time.Since(start))
+	if err != nil {
+		slog.Warn("Error sending request", "postcode", postcode, "err", err)
+		return PostcodeResult{Postcode: postcode, Status: StatusNetworkError, Error: fmt.Sprintf("error sending request: %v", err)}, false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+		slog.Warn("Rate limited", "postcode", postcode, "retry_after", retryAfter)
+		return PostcodeResult{
+			Postcode:   postcode,
+			Status:     StatusRateLimited,
+			HTTPStatus: resp.StatusCode,
+			Error:      "rate limited",
+			RetryAfter: retryAfter,
+		}, false
+	}
+
+	// Drupal rejects submissions with an expired form token with a client error
+	if resp.StatusCode != http.StatusOK {
+		slog.Warn("Received non-OK HTTP status", "postcode", postcode, "status", resp.Status)
+		tokenRejected = resp.StatusCode >= 400 && resp.StatusCode < 500
+		return PostcodeResult{
+			Postcode:   postcode,
+			Status:     StatusNetworkError,
+			HTTPStatus: resp.StatusCode,
+			Error:      "received non-OK HTTP status: " + resp.Status,
+		}, tokenRejected
+	}
+
+	// Read the response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		slog.Warn("Error reading response", "postcode", postcode, "err", err)
+		return PostcodeResult{
+			Postcode:   postcode,
+			Status:     StatusNetworkError,
+			HTTPStatus: resp.StatusCode,
+			Error:      fmt.Sprintf("error reading response: %v", err),
+		}, false
+	}
+
+	// An HTML page with a 200 status is usually a bot challenge or error page rather than an
+	// answer, so say so instead of failing to parse it as JSON
+	if contentType := resp.Header.Get("Content-Type"); !isJSONContentType(contentType) {
+		slog.Warn("Unexpected response content type, possibly blocked", "postcode", postcode, "content_type", contentType)
+		f.saveDebugResponse(postcode, ".html", body)
+		return PostcodeResult{
+			Postcode:   postcode,
+			Status:     StatusParseError,
+			HTTPStatus: resp.StatusCode,
+			Error:      fmt.Sprintf("unexpected content type %q, expected JSON: possibly a challenge or error page", contentType),
+		}, false
+	}
+
+	// Parse the JSON response
+	ajaxResponse, err := parseAjaxResponse(body)
+	if err != nil {
+		if errors.Is(err, ErrSchemaChanged) {
+			slog.Error("Response schema changed, the scraper may need updating", "postcode", postcode, "err", err)
+		} else {
+			slog.Warn("Error parsing JSON response", "postcode", postcode, "err", err)
+		}
+		f.saveDebugResponse(postcode, ".json", body)
+		return PostcodeResult{
+			Postcode:   postcode,
+			Status:     StatusParseError,
+			HTTPStatus: resp.StatusCode,
+			Error:      err.Error(),
+		}, false
+	}
+
+	// Find the command carrying the rendered supplier markup rather than assuming its position
+	for _, command := range ajaxResponse {
+		if !strings.Contains(command.Data, "<") {
+			continue
+		}
+
+		found, err := f.Extractor.Extract(command.Data)
+		if errors.Is(err, ErrNoSupplier) {
+			continue
+		}
+		if err != nil {
+			// Supplier markup is present but couldn't be read
+			slog.Warn("Error extracting supplier", "postcode", postcode, "err", err)
+			f.saveDebugResponse(postcode, ".html", []byte(command.Data))
+			return PostcodeResult{
+				Postcode:   postcode,
+				Supplier:   "Not Found",
+				Phone:      "Not Found",
+				Link:       "Not Found",
+				Status:     StatusParseError,
+				HTTPStatus: resp.StatusCode,
+				Error:      err.Error(),
+			}, false
+		}
+
+		slog.Debug("Extracted results", "postcode", postcode, "supplier", found.Supplier, "link", found.Link)
+		found.Postcode = postcode
+		found.Status = StatusFound
+		found.HTTPStatus = resp.StatusCode
+		return found, false
+	}
+
+	slog.Warn("No supplier markup in response", "postcode", postcode, "commands", len(ajaxResponse))
+	f.saveDebugResponse(postcode, ".json", body)
+	// A rejected token gets a short response without the rendered supplier markup;
+	// otherwise a valid response without any supplier means the postcode isn't covered
+	tokenRejected = len(ajaxResponse) < 3
+	return PostcodeResult{
+		Postcode:   postcode,
+		Supplier:   "Not Found",
+		Phone:      "Not Found",
+		Link:       "Not Found",
+		Status:     StatusNotFound,
+		HTTPStatus: resp.StatusCode,
+	}, tokenRejected
+}
+
+// isJSONContentType reports whether a Content-Type header could be a JSON response. A
+// missing header is given the benefit of the doubt.
+func isJSONContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") ||
+		mediaType == "text/javascript" || mediaType == "application/javascript"
+}
+
+// saveDebugResponse writes a response that couldn't be parsed to DebugDir, named by postcode,
+// so the markup can be inspected when selectors stop matching
+func (f *Fetcher) saveDebugResponse(postcode, ext string, data []byte) {
+	if f.DebugDir == "" {
+		return
+	}
+
+	filename := filepath.Join(f.DebugDir, strings.ReplaceAll(postcode, " ", "_")+ext)
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		slog.Warn("Error saving debug response", "postcode", postcode, "err", err)
+		return
+	}
+	slog.Debug("Saved debug response", "postcode", postcode, "file", filename)
+}
+
+// parseRetryAfter converts a Retry-After header, given either as delay seconds or an
+// HTTP date, into a duration. It returns zero when the header is missing or invalid.
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+
+	return 0
+}
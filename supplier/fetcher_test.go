@@ -0,0 +1,291 @@
+package supplier
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testSupplierMarkup = `<div class="supplier"><h2 class="supplier__name">Thames Water</h2>` +
+	`<p class="supplier__phone">General enquiries call <b>0800 316 9800</b></p>` +
+	`<a class="supplier__link" href="https://www.thameswater.co.uk">Visit</a></div>`
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		status     LookupStatus
+		httpStatus int
+		want       bool
+	}{
+		{StatusRateLimited, http.StatusTooManyRequests, true},
+		{StatusParseError, http.StatusOK, true},
+		{StatusNetworkError, 0, true},
+		{StatusNetworkError, http.StatusInternalServerError, true},
+		{StatusNetworkError, http.StatusBadGateway, true},
+		{StatusNetworkError, http.StatusBadRequest, false},
+		{StatusNetworkError, http.StatusForbidden, false},
+		{StatusNetworkError, http.StatusNotFound, false},
+		{StatusFound, http.StatusOK, false},
+		{StatusNotFound, http.StatusOK, false},
+	}
+
+	for _, tt := range tests {
+		got := retryable(PostcodeResult{Status: tt.status, HTTPStatus: tt.httpStatus})
+		if got != tt.want {
+			t.Errorf("retryable(%s, %d) = %v, want %v", tt.status, tt.httpStatus, got, tt.want)
+		}
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration // Delay before jitter
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{4, 16 * time.Second},
+		{5, 30 * time.Second},
+		{50, 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		for range 20 {
+			got := retryBackoff(tt.attempt, time.Second, 30*time.Second)
+			if got < tt.want || got >= tt.want+tt.want/4 {
+				t.Errorf("retryBackoff(%d) = %s, want in [%s, %s)", tt.attempt, got, tt.want, tt.want+tt.want/4)
+				break
+			}
+		}
+	}
+}
+
+// newTestFetcher returns a fetcher talking to a server that serves the form page itself and
+// answers submissions with submit, retrying without meaningful delays
+func newTestFetcher(t *testing.T, submit http.HandlerFunc) *Fetcher {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<form><input name="form_build_id" value="tok"></form>`))
+			return
+		}
+		submit(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	f := NewFetcher()
+	f.Client = srv.Client()
+	f.Endpoint = srv.URL
+	f.FormURL = srv.URL
+	f.RetryDelay = time.Millisecond
+	f.MaxRetryDelay = time.Millisecond
+	return f
+}
+
+// writeSupplierResponse answers a submission the way the site does for a covered postcode
+func writeSupplierResponse(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode([]map[string]string{{"command": "insert", "data": testSupplierMarkup}})
+}
+
+func TestLookupWithRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int // Submissions answered with failStatus before succeeding
+		failStatus   int
+		wantStatus   LookupStatus
+		wantAttempts int
+		wantPosts    int32
+	}{
+		{"first time", 0, 0, StatusFound, 1, 1},
+		{"after server errors", 2, http.StatusInternalServerError, StatusFound, 3, 3},
+		{"after rate limiting", 1, http.StatusTooManyRequests, StatusFound, 2, 2},
+		{"server errors exhaust retries", 5, http.StatusServiceUnavailable, StatusNetworkError, 3, 3},
+		// A client error refreshes the token and resubmits once, but isn't retried
+		{"client error not retried", 5, http.StatusForbidden, StatusNetworkError, 1, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var posts atomic.Int32
+			f := newTestFetcher(t, func(w http.ResponseWriter, r *http.Request) {
+				if int(posts.Add(1)) <= tt.failures {
+					w.WriteHeader(tt.failStatus)
+					return
+				}
+				writeSupplierResponse(w)
+			})
+
+			result := f.getSupplierForPostcodeWithRetries(context.Background(), "SW1A 1AA")
+			if result.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s (err %q)", result.Status, tt.wantStatus, result.Error)
+			}
+			if result.Attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", result.Attempts, tt.wantAttempts)
+			}
+			if got := posts.Load(); got != tt.wantPosts {
+				t.Errorf("submissions = %d, want %d", got, tt.wantPosts)
+			}
+		})
+	}
+}
+
+func TestLookupWithRetriesCapsRetryAfter(t *testing.T) {
+	var posts atomic.Int32
+	f := newTestFetcher(t, func(w http.ResponseWriter, r *http.Request) {
+		if posts.Add(1) == 1 {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		writeSupplierResponse(w)
+	})
+	f.MaxRetryDelay = 10 * time.Millisecond
+
+	start := time.Now()
+	result := f.getSupplierForPostcodeWithRetries(context.Background(), "SW1A 1AA")
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("getSupplierForPostcodeWithRetries waited %s for an hour's Retry-After", elapsed)
+	}
+	if result.Status != StatusFound || result.Attempts != 2 {
+		t.Errorf("result = %s after %d attempts, want %s after 2", result.Status, result.Attempts, StatusFound)
+	}
+}
+
+func TestLookupWithRetryAfterCancelled(t *testing.T) {
+	f := newTestFetcher(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	f.MaxRetryDelay = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	result := f.getSupplierForPostcodeWithRetries(ctx, "SW1A 1AA")
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("getSupplierForPostcodeWithRetries took %s after cancellation", elapsed)
+	}
+	if result.Status != StatusRateLimited || result.Attempts != 1 {
+		t.Errorf("result = %s after %d attempts, want %s after 1", result.Status, result.Attempts, StatusRateLimited)
+	}
+}
+
+func TestLookupWithRetriesCancelled(t *testing.T) {
+	f := newTestFetcher(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	f.RetryDelay = time.Hour
+	f.MaxRetryDelay = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	result := f.getSupplierForPostcodeWithRetries(ctx, "SW1A 1AA")
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("getSupplierForPostcodeWithRetries took %s after cancellation", elapsed)
+	}
+	if result.Status != StatusNetworkError || result.Attempts != 1 {
+		t.Errorf("result = %s after %d attempts, want %s after 1", result.Status, result.Attempts, StatusNetworkError)
+	}
+}
+
+func TestSubmitPostcode(t *testing.T) {
+	tests := []struct {
+		name         string
+		commands     []string // Data of each AJAX command in the response
+		wantSupplier string
+		wantRejected bool
+	}{
+		{"markup in third command", []string{"", "<p>settings</p>", testSupplierMarkup}, "Thames Water", false},
+		{"markup in second of two commands", []string{"", testSupplierMarkup}, "Thames Water", false},
+		{"markup in first command", []string{testSupplierMarkup}, "Thames Water", false},
+		{"two commands without markup", []string{"", "<p>settings</p>"}, "Not Found", true},
+		{"three commands without markup", []string{"", "", "<p>No supplier found</p>"}, "Not Found", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var commands []map[string]string
+				for _, data := range tt.commands {
+					commands = append(commands, map[string]string{"command": "insert", "data": data})
+				}
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(commands)
+			}))
+			defer srv.Close()
+
+			f := NewFetcher()
+			f.Client = srv.Client()
+			f.Endpoint = srv.URL
+			got, rejected := f.submitPostcode(context.Background(), "SW1A 1AA", "tok")
+			if got.Supplier != tt.wantSupplier || rejected != tt.wantRejected {
+				t.Errorf("submitPostcode() = %q, %v, want %q, %v", got.Supplier, rejected, tt.wantSupplier, tt.wantRejected)
+			}
+		})
+	}
+}
+
+// BenchmarkHTTPClient compares concurrent requests through a default client, which keeps only
+// two idle connections per host, with the shared client sized for the worker count
+func BenchmarkHTTPClient(b *testing.B) {
+	const workers = 16
+
+	// Count the connections opened, which is what the idle pool sizing saves
+	var dials atomic.Int64
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"command":"insert","data":"<p>ok</p>"}]`))
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			dials.Add(1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	tuned, err := NewHTTPClient(ClientOptions{Concurrency: workers})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	clients := []struct {
+		name   string
+		client *http.Client
+	}{
+		{"default", &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()}},
+		{"tuned", tuned},
+	}
+
+	for _, c := range clients {
+		b.Run(c.name, func(b *testing.B) {
+			dials.Store(0)
+			// Spread the requests over workers goroutines whatever GOMAXPROCS is
+			b.SetParallelism(max(1, workers/runtime.GOMAXPROCS(0)))
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					resp, err := c.client.Get(srv.URL)
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+				}
+			})
+			b.ReportMetric(float64(dials.Load()), "conns")
+		})
+	}
+}
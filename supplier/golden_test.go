@@ -0,0 +1,76 @@
+package supplier
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files under testdata")
+
+// ajaxFixtures returns the recorded lookup responses under testdata/ajax, keyed by name
+func ajaxFixtures(tb testing.TB) map[string][]byte {
+	tb.Helper()
+
+	files, err := filepath.Glob(filepath.Join("testdata", "ajax", "*.json"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	fixtures := make(map[string][]byte)
+	for _, file := range files {
+		if strings.HasSuffix(file, ".golden.json") {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		fixtures[strings.TrimSuffix(filepath.Base(file), ".json")] = data
+	}
+	if len(fixtures) == 0 {
+		tb.Fatal("no fixtures in testdata/ajax")
+	}
+	return fixtures
+}
+
+// TestLookupGolden submits a postcode to a server answering with each recorded response and
+// compares the result with its golden file. Run with -update to rewrite the golden files
+// after an intended change to extraction.
+func TestLookupGolden(t *testing.T) {
+	for name, body := range ajaxFixtures(t) {
+		t.Run(name, func(t *testing.T) {
+			f := newTestFetcher(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write(body)
+			})
+
+			result := f.getSupplierForPostcodeWithRetries(context.Background(), "SW1A 1AA")
+			result.FetchedAt = time.Time{}
+			got, err := json.MarshalIndent(result, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+
+			golden := filepath.Join("testdata", "ajax", name+".golden.json")
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("error reading golden file: %v", err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("result differs from %s:\n%s\nwant\n%s", golden, got, want)
+			}
+		})
+	}
+}
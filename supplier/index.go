@@ -0,0 +1,165 @@
+package supplier
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The processed index holds one fixed-size record per postcode, sorted by postcode: the
+// postcode space-padded to indexPostcodeWidth bytes, then when it was fetched as big-endian
+// Unix seconds. Fixed-size records let it be binary searched in place.
+const (
+	indexPostcodeWidth = 8 // Longest normalized postcode, e.g. "SW1A 1AA"
+	indexRecordSize    = indexPostcodeWidth + 8
+)
+
+// postcodeIndex is an on-disk sorted index of processed postcodes and when each was
+// fetched, searched in place so huge corpora never have to be loaded into memory
+type postcodeIndex struct {
+	file  *os.File
+	count int64
+}
+
+// openPostcodeIndex opens the index at path for lookups
+func openPostcodeIndex(path string) (*postcodeIndex, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening processed index: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error reading processed index: %v", err)
+	}
+	if info.Size()%indexRecordSize != 0 {
+		file.Close()
+		return nil, fmt.Errorf("processed index %s is corrupt: size %d isn't a whole number of records", path, info.Size())
+	}
+	return &postcodeIndex{file: file, count: info.Size() / indexRecordSize}, nil
+}
+
+// lookup returns when postcode was fetched, binary searching the index on disk
+func (x *postcodeIndex) lookup(postcode string) (time.Time, bool, error) {
+	key := indexKey(postcode)
+	record := make([]byte, indexRecordSize)
+
+	lo, hi := int64(0), x.count
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if _, err := x.file.ReadAt(record, mid*indexRecordSize); err != nil {
+			return time.Time{}, false, fmt.Errorf("error reading processed index: %v", err)
+		}
+		switch strings.Compare(string(record[:indexPostcodeWidth]), key) {
+		case 0:
+			return indexTime(record), true, nil
+		case -1:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return time.Time{}, false, nil
+}
+
+// Close closes the index file
+func (x *postcodeIndex) Close() error {
+	return x.file.Close()
+}
+
+// writePostcodeIndex writes a new index to path holding the records of old (which may be
+// nil) merged with added, whose entries replace any in old for the same postcode.
+// Postcodes too long to be indexed are left out.
+func writePostcodeIndex(path string, old *postcodeIndex, added map[string]time.Time) error {
+	keys := make([]string, 0, len(added))
+	for postcode := range added {
+		if len(postcode) <= indexPostcodeWidth {
+			keys = append(keys, indexKey(postcode))
+		}
+	}
+	slices.Sort(keys)
+
+	err := writeFileAtomic(path, func(w io.Writer) error {
+		buf := bufio.NewWriter(w)
+		record := make([]byte, indexRecordSize)
+		writeRecord := func(key string, fetchedAt time.Time) error {
+			copy(record, key)
+			binary.BigEndian.PutUint64(record[indexPostcodeWidth:], uint64(fetchedAt.Unix()))
+			_, err := buf.Write(record)
+			return err
+		}
+
+		// Merge the sorted old records with the sorted additions
+		var oldRecords *bufio.Reader
+		if old != nil {
+			oldRecords = bufio.NewReader(io.NewSectionReader(old.file, 0, old.count*indexRecordSize))
+		}
+		oldRecord := make([]byte, indexRecordSize)
+		for i := int64(0); old != nil && i < old.count; i++ {
+			if _, err := io.ReadFull(oldRecords, oldRecord); err != nil {
+				return fmt.Errorf("error reading processed index: %v", err)
+			}
+			oldKey := string(oldRecord[:indexPostcodeWidth])
+			for len(keys) > 0 && keys[0] < oldKey {
+				if err := writeRecord(keys[0], added[strings.TrimRight(keys[0], " ")]); err != nil {
+					return err
+				}
+				keys = keys[1:]
+			}
+			if len(keys) > 0 && keys[0] == oldKey {
+				continue // Superseded by the addition for the same postcode, written next
+			}
+			if _, err := buf.Write(oldRecord); err != nil {
+				return err
+			}
+		}
+		for _, key := range keys {
+			if err := writeRecord(key, added[strings.TrimRight(key, " ")]); err != nil {
+				return err
+			}
+		}
+		return buf.Flush()
+	})
+	if err != nil {
+		return fmt.Errorf("error writing processed index: %v", err)
+	}
+	return nil
+}
+
+// indexFresh reports whether the index at path exists and was written after every one of
+// sources that exists was last modified, so it still covers all of their results
+func indexFresh(path string, sources ...string) bool {
+	index, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	for _, source := range sources {
+		info, err := os.Stat(source)
+		if err != nil {
+			continue
+		}
+		if index.ModTime().Before(info.ModTime()) {
+			return false
+		}
+	}
+	return true
+}
+
+// indexKey pads postcode to the fixed width stored in the index
+func indexKey(postcode string) string {
+	return fmt.Sprintf("%-*s", indexPostcodeWidth, postcode)
+}
+
+// indexTime decodes the fetch time stored in a record, zero for results without one
+func indexTime(record []byte) time.Time {
+	seconds := int64(binary.BigEndian.Uint64(record[indexPostcodeWidth:]))
+	if seconds == (time.Time{}).Unix() {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0).UTC()
+}
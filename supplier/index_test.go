@@ -0,0 +1,137 @@
+package supplier
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testIndexPostcodes returns n distinct postcode-shaped keys of the width stored in the index
+func testIndexPostcodes(n int) []string {
+	postcodes := make([]string, n)
+	for i := range postcodes {
+		postcodes[i] = fmt.Sprintf("X%03d %03d", i/1000, i%1000)
+	}
+	return postcodes
+}
+
+func TestPostcodeIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "processed.idx")
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+
+	err := writePostcodeIndex(path, nil, map[string]time.Time{"SW1A 1AA": older, "M1 1AE": older, "B33 8TH": {}})
+	if err != nil {
+		t.Fatalf("writePostcodeIndex() error = %v", err)
+	}
+	old, err := openPostcodeIndex(path)
+	if err != nil {
+		t.Fatalf("openPostcodeIndex() error = %v", err)
+	}
+
+	// Merging replaces existing postcodes and leaves out ones too long to index
+	merged := filepath.Join(t.TempDir(), "merged.idx")
+	err = writePostcodeIndex(merged, old, map[string]time.Time{"M1 1AE": newer, "A1 1AA": newer, "TOOLONG 1AA": newer})
+	old.Close()
+	if err != nil {
+		t.Fatalf("writePostcodeIndex() error = %v", err)
+	}
+	index, err := openPostcodeIndex(merged)
+	if err != nil {
+		t.Fatalf("openPostcodeIndex() error = %v", err)
+	}
+	defer index.Close()
+
+	if index.count != 4 {
+		t.Errorf("index holds %d records, want 4", index.count)
+	}
+	tests := []struct {
+		postcode string
+		want     time.Time
+		wantOK   bool
+	}{
+		{"A1 1AA", newer, true},
+		{"B33 8TH", time.Time{}, true},
+		{"M1 1AE", newer, true},
+		{"SW1A 1AA", older, true},
+		{"SW1A 2AA", time.Time{}, false},
+		{"TOOLONG 1AA", time.Time{}, false},
+		{"", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok, err := index.lookup(tt.postcode)
+		if err != nil {
+			t.Fatalf("lookup(%q) error = %v", tt.postcode, err)
+		}
+		if !got.Equal(tt.want) || ok != tt.wantOK {
+			t.Errorf("lookup(%q) = %s, %v, want %s, %v", tt.postcode, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestOpenPostcodeIndexCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "processed.idx")
+	writeTestFile(t, filepath.Dir(path), filepath.Base(path), "not a whole record")
+	if _, err := openPostcodeIndex(path); err == nil {
+		t.Error("openPostcodeIndex(corrupt) error = nil, want error")
+	}
+}
+
+func BenchmarkPostcodeIndexLookup(b *testing.B) {
+	postcodes := testIndexPostcodes(100000)
+	added := make(map[string]time.Time, len(postcodes))
+	fetchedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, postcode := range postcodes {
+		added[postcode] = fetchedAt
+	}
+
+	path := filepath.Join(b.TempDir(), "processed.idx")
+	if err := writePostcodeIndex(path, nil, added); err != nil {
+		b.Fatalf("writePostcodeIndex() error = %v", err)
+	}
+	index, err := openPostcodeIndex(path)
+	if err != nil {
+		b.Fatalf("openPostcodeIndex() error = %v", err)
+	}
+	defer index.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok, err := index.lookup(postcodes[i%len(postcodes)]); !ok || err != nil {
+			b.Fatalf("lookup() = %v, %v, want found", ok, err)
+		}
+	}
+}
+
+func BenchmarkWritePostcodeIndex(b *testing.B) {
+	postcodes := testIndexPostcodes(100000)
+	fetchedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	old := make(map[string]time.Time)
+	added := make(map[string]time.Time)
+	for i, postcode := range postcodes {
+		if i%10 == 0 {
+			added[postcode] = fetchedAt
+		} else {
+			old[postcode] = fetchedAt
+		}
+	}
+
+	dir := b.TempDir()
+	oldPath := filepath.Join(dir, "old.idx")
+	if err := writePostcodeIndex(oldPath, nil, old); err != nil {
+		b.Fatalf("writePostcodeIndex() error = %v", err)
+	}
+	index, err := openPostcodeIndex(oldPath)
+	if err != nil {
+		b.Fatalf("openPostcodeIndex() error = %v", err)
+	}
+	defer index.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := writePostcodeIndex(filepath.Join(dir, "new.idx"), index, added); err != nil {
+			b.Fatalf("writePostcodeIndex() error = %v", err)
+		}
+	}
+}
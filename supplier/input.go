@@ -0,0 +1,217 @@
+package supplier
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// sniffedDelimiters are the field delimiters auto-detection chooses between
+var sniffedDelimiters = []rune{',', ';', '\t', '|'}
+
+// csvOptions controls how postcode CSV files are read
+type csvOptions struct {
+	HasHeader bool // Always skip the first row; otherwise it is only skipped when it isn't a postcode
+	Column    int  // Zero-based index of the column holding the postcode
+	Comma     rune // Field delimiter, detected from the first line when zero
+}
+
+// csvStats counts the rows of a CSV file that didn't yield a postcode
+type csvStats struct {
+	Headers int // Header rows skipped
+	Invalid int // Rows that aren't valid UK postcodes or are too short to have one
+}
+
+// getPostcodesFromCSV reads a single CSV file and extracts normalized postcodes.
+// A header row is skipped, as are rows that aren't valid UK postcodes; both are counted
+// in stats.
+func getPostcodesFromCSV(filePath string, opts csvOptions) (postcodes []string, stats csvStats, err error) {
+	// Open the CSV file
+	csvFile, err := os.Open(filePath)
+	if err != nil {
+		return nil, stats, fmt.Errorf("could not open file: %v", err)
+	}
+	defer csvFile.Close()
+
+	// Decompress gzipped files on the fly
+	var input io.Reader = csvFile
+	if strings.HasSuffix(filePath, ".gz") {
+		gz, err := gzip.NewReader(csvFile)
+		if err != nil {
+			return nil, stats, fmt.Errorf("error opening gzip stream: %v", err)
+		}
+		defer gz.Close()
+		input = gz
+	}
+
+	buf := skipBOM(input)
+	comma := opts.Comma
+	if comma == 0 {
+		comma = sniffDelimiter(buf)
+		slog.Debug("Detected delimiter", "file", filePath, "delimiter", string(comma))
+	}
+
+	reader := csv.NewReader(buf)
+	reader.Comma = comma
+	reader.FieldsPerRecord = -1 // Rows are bounds-checked individually below
+
+	// Read each row of the CSV
+	for row := 0; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, stats, fmt.Errorf("error reading CSV file: %v", err)
+		}
+
+		if row == 0 && opts.HasHeader {
+			stats.Headers++
+			continue
+		}
+
+		if opts.Column >= len(record) {
+			slog.Warn("Skipping row without a postcode column", "file", filePath, "row", row+1, "columns", len(record))
+			stats.Invalid++
+			continue
+		}
+
+		// Extract postcode from the configured column and remove quotes if present
+		raw := strings.Trim(strings.TrimSpace(record[opts.Column]), "\"")
+		postcode, ok := normalizePostcode(raw)
+		if !ok {
+			// A first row that isn't a postcode is taken to be a header, e.g. from an Excel export
+			if row == 0 {
+				slog.Debug("Skipping header row", "file", filePath, "value", raw)
+				stats.Headers++
+				continue
+			}
+			slog.Debug("Skipping invalid postcode", "file", filePath, "postcode", raw)
+			stats.Invalid++
+			continue
+		}
+		postcodes = append(postcodes, postcode)
+	}
+
+	return postcodes, stats, nil
+}
+
+// skipBOM returns a reader over r without its leading UTF-8 byte order mark, if it has one.
+// Files exported from Windows tools often start with one, which would otherwise corrupt the
+// first postcode.
+func skipBOM(r io.Reader) *bufio.Reader {
+	buf := bufio.NewReader(r)
+	if bom, err := buf.Peek(3); err == nil && string(bom) == "\ufeff" {
+		buf.Discard(3)
+	}
+	return buf
+}
+
+// parseDelimiter parses a CSV field delimiter given as a single character, "tab", or "auto"
+// to detect it from each file's first line, returned as zero. An empty delimiter is a comma.
+func parseDelimiter(delimiter string) (rune, error) {
+	switch delimiter {
+	case "", ",":
+		return ',', nil
+	case "auto":
+		return 0, nil
+	case "tab", `\t`:
+		return '\t', nil
+	}
+
+	comma, size := utf8.DecodeRuneInString(delimiter)
+	switch {
+	case size != len(delimiter):
+		return 0, fmt.Errorf("invalid delimiter %q: must be a single character, tab, or auto", delimiter)
+	case comma == utf8.RuneError || comma == '"' || comma == '\r' || comma == '\n':
+		return 0, fmt.Errorf("invalid delimiter %q: can't be a quote or line break", delimiter)
+	}
+	return comma, nil
+}
+
+// sniffDelimiter peeks at the first line buffered in buf and returns whichever of
+// sniffedDelimiters appears in it most often outside quotes, or a comma when none do, as
+// single-column files have no delimiter at all
+func sniffDelimiter(buf *bufio.Reader) rune {
+	// A line longer than the buffer is judged on what fits
+	line, _ := buf.Peek(buf.Size())
+	if end := bytes.IndexByte(line, '\n'); end >= 0 {
+		line = line[:end]
+	}
+
+	counts := make(map[rune]int)
+	quoted := false
+	for _, r := range string(line) {
+		if r == '"' {
+			quoted = !quoted
+			continue
+		}
+		if !quoted {
+			counts[r]++
+		}
+	}
+
+	comma := ','
+	for _, candidate := range sniffedDelimiters {
+		if counts[candidate] > counts[comma] {
+			comma = candidate
+		}
+	}
+	return comma
+}
+
+// readPostcodeLines reads newline-delimited postcodes from source, skipping blank lines and
+// counting lines that aren't valid UK postcodes in invalid
+func readPostcodeLines(r io.Reader, source string) (postcodes []string, invalid int, err error) {
+	scanner := bufio.NewScanner(skipBOM(r))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		postcode, ok := normalizePostcode(line)
+		if !ok {
+			slog.Debug("Skipping invalid postcode", "file", source, "postcode", line)
+			invalid++
+			continue
+		}
+		postcodes = append(postcodes, postcode)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error reading postcodes: %v", err)
+	}
+
+	return postcodes, invalid, nil
+}
+
+// loadPostcodeList reads the newline-delimited postcodes in filename, such as a skip list
+// or allowlist, normalized so they match however they are written in the input
+func loadPostcodeList(filename string) (map[string]bool, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening postcode list: %v", err)
+	}
+	defer file.Close()
+
+	postcodes, invalid, err := readPostcodeLines(file, filename)
+	if err != nil {
+		return nil, err
+	}
+	if invalid > 0 {
+		slog.Warn("Skipped invalid postcodes", "file", filename, "count", invalid)
+	}
+
+	list := make(map[string]bool, len(postcodes))
+	for _, postcode := range postcodes {
+		list[postcode] = true
+	}
+	return list, nil
+}
@@ -0,0 +1,241 @@
+package supplier
+
+import (
+	"bufio"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTestFile writes data to name in dir, gzipping it when the name ends in .gz
+func writeTestFile(t *testing.T, dir, name, data string) string {
+	t.Helper()
+
+	filename := filepath.Join(dir, name)
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if !strings.HasSuffix(name, ".gz") {
+		if _, err := file.WriteString(data); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+	gz := gzip.NewWriter(file)
+	if _, err := gz.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestGetPostcodesFromCSV(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      string
+		data      string
+		opts      csvOptions
+		want      []string
+		wantStats csvStats
+	}{
+		{
+			name: "single column without header",
+			file: "a.csv",
+			data: "SW1A 1AA\nsw1a2aa\n",
+			opts: csvOptions{Comma: ','},
+			want: []string{"SW1A 1AA", "SW1A 2AA"},
+		},
+		{
+			name:      "header detected and invalid rows counted",
+			file:      "a.csv",
+			data:      "Postcode\nSW1A 1AA\nnot a postcode\n\"M1 1AE\"\n",
+			opts:      csvOptions{Comma: ','},
+			want:      []string{"SW1A 1AA", "M1 1AE"},
+			wantStats: csvStats{Headers: 1, Invalid: 1},
+		},
+		{
+			name:      "forced header and column",
+			file:      "a.csv",
+			data:      "SW1A 1AA,B33 8TH\n1,SW1A 2AA\n2\n",
+			opts:      csvOptions{HasHeader: true, Column: 1, Comma: ','},
+			want:      []string{"SW1A 2AA"},
+			wantStats: csvStats{Headers: 1, Invalid: 1},
+		},
+		{
+			name:      "byte order mark",
+			file:      "a.csv",
+			data:      "\ufeffSW1A 1AA\n",
+			opts:      csvOptions{Comma: ','},
+			want:      []string{"SW1A 1AA"},
+			wantStats: csvStats{},
+		},
+		{
+			name:      "sniffed semicolons",
+			file:      "a.csv",
+			data:      "id;postcode\n1;SW1A 1AA\n2;\"M1 1AE\"\n",
+			opts:      csvOptions{Column: 1},
+			want:      []string{"SW1A 1AA", "M1 1AE"},
+			wantStats: csvStats{Headers: 1},
+		},
+		{
+			name: "gzipped",
+			file: "a.csv.gz",
+			data: "SW1A 1AA\nB33 8TH\n",
+			opts: csvOptions{Comma: ','},
+			want: []string{"SW1A 1AA", "B33 8TH"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := writeTestFile(t, t.TempDir(), tt.file, tt.data)
+			got, stats, err := getPostcodesFromCSV(filename, tt.opts)
+			if err != nil {
+				t.Fatalf("getPostcodesFromCSV() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) || stats != tt.wantStats {
+				t.Errorf("getPostcodesFromCSV() = %v, %+v, want %v, %+v", got, stats, tt.want, tt.wantStats)
+			}
+		})
+	}
+}
+
+func TestGetPostcodesFromCSVErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, _, err := getPostcodesFromCSV(filepath.Join(dir, "missing.csv"), csvOptions{}); err == nil {
+		t.Error("getPostcodesFromCSV(missing) error = nil, want error")
+	}
+
+	notGzip := writeTestFile(t, dir, "plain.csv", "SW1A 1AA\n")
+	if err := os.Rename(notGzip, notGzip+".gz"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := getPostcodesFromCSV(notGzip+".gz", csvOptions{}); err == nil {
+		t.Error("getPostcodesFromCSV(not gzipped) error = nil, want error")
+	}
+}
+
+func TestParseDelimiter(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    rune
+		wantErr bool
+	}{
+		{"", ',', false},
+		{",", ',', false},
+		{"auto", 0, false},
+		{"tab", '\t', false},
+		{`\t`, '\t', false},
+		{";", ';', false},
+		{"|", '|', false},
+		{"¦", '¦', false},
+		{";;", 0, true},
+		{`"`, 0, true},
+		{"\n", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseDelimiter(tt.in)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parseDelimiter(%q) = %q, %v, want %q, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSniffDelimiter(t *testing.T) {
+	tests := []struct {
+		data string
+		want rune
+	}{
+		{"SW1A 1AA\n", ','},
+		{"id,postcode\n", ','},
+		{"id;postcode;name\n", ';'},
+		{"id\tpostcode\n", '\t'},
+		{"id|postcode\n", '|'},
+		{"\"a,b,c\";postcode\n", ';'},
+		{"id;postcode\n1,2,3,4\n", ';'},
+	}
+
+	for _, tt := range tests {
+		if got := sniffDelimiter(bufio.NewReader(strings.NewReader(tt.data))); got != tt.want {
+			t.Errorf("sniffDelimiter(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestReadPostcodeLines(t *testing.T) {
+	data := "\ufeffSW1A 1AA\n\n  m11ae  \nnot a postcode\r\nB33 8TH"
+	got, invalid, err := readPostcodeLines(strings.NewReader(data), "list")
+	if err != nil {
+		t.Fatalf("readPostcodeLines() error = %v", err)
+	}
+	if want := []string{"SW1A 1AA", "M1 1AE", "B33 8TH"}; !reflect.DeepEqual(got, want) || invalid != 1 {
+		t.Errorf("readPostcodeLines() = %v, %d, want %v, 1", got, invalid, want)
+	}
+}
+
+func TestLoadPostcodeList(t *testing.T) {
+	filename := writeTestFile(t, t.TempDir(), "skip.txt", "sw1a1aa\nM1 1AE\n")
+	got, err := loadPostcodeList(filename)
+	if err != nil {
+		t.Fatalf("loadPostcodeList() error = %v", err)
+	}
+	if want := map[string]bool{"SW1A 1AA": true, "M1 1AE": true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("loadPostcodeList() = %v, want %v", got, want)
+	}
+}
+
+func TestPostcodeFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.csv", "a.csv.gz", "c.csv", "notes.txt", "d.json"} {
+		writeTestFile(t, dir, name, "SW1A 1AA\n")
+	}
+
+	files, err := postcodeFiles(dir)
+	if err != nil {
+		t.Fatalf("postcodeFiles() error = %v", err)
+	}
+	want := []string{filepath.Join(dir, "a.csv.gz"), filepath.Join(dir, "b.csv"), filepath.Join(dir, "c.csv")}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("postcodeFiles() = %v, want %v", files, want)
+	}
+
+	if _, err := postcodeFiles(filepath.Join(dir, "missing")); err == nil {
+		t.Error("postcodeFiles(missing) error = nil, want error")
+	}
+	if _, err := postcodeFiles(filepath.Join(dir, "b.csv")); err == nil {
+		t.Error("postcodeFiles(file) error = nil, want error")
+	}
+}
+
+func TestFileRange(t *testing.T) {
+	files := []string{"in/a.csv", "in/b.csv", "in/c.csv", "in/d.csv"}
+	tests := []struct {
+		start, end string
+		want       []string
+		wantErr    bool
+	}{
+		{"", "", files, false},
+		{"b.csv", "", files[1:], false},
+		{"", "c.csv", files[:3], false},
+		{"b.csv", "b.csv", files[1:2], false},
+		{"x.csv", "", nil, true},
+		{"", "x.csv", nil, true},
+		{"c.csv", "b.csv", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := fileRange(files, tt.start, tt.end)
+		if !reflect.DeepEqual(got, tt.want) || (err != nil) != tt.wantErr {
+			t.Errorf("fileRange(%q, %q) = %v, %v, want %v, error %v", tt.start, tt.end, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !unix && !windows
+
+package supplier
+
+import (
+	"fmt"
+	"os"
+)
+
+// lockFile refuses to run, as there is no file locking on this platform to stop two runs
+// clobbering each other's progress and results
+func lockFile(path string) (*os.File, error) {
+	return nil, fmt.Errorf("error locking %s: file locking isn't supported on this platform", path)
+}
+
+// unlockFile releases a lock taken by lockFile
+func unlockFile(f *os.File) error {
+	return f.Close()
+}
@@ -0,0 +1,37 @@
+//go:build unix
+
+package supplier
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive flock on path, creating the file if needed. It fails straight
+// away rather than waiting when another process already holds the lock.
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening lock file: %v", err)
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("another run is already in progress in this directory (%s is locked)", path)
+		}
+		return nil, fmt.Errorf("error locking %s: %v", path, err)
+	}
+	return f, nil
+}
+
+// unlockFile releases a lock taken by lockFile
+func unlockFile(f *os.File) error {
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_UN); err != nil {
+		return fmt.Errorf("error unlocking %s: %v", f.Name(), err)
+	}
+	return nil
+}
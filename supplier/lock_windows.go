@@ -0,0 +1,39 @@
+//go:build windows
+
+package supplier
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockFile takes an exclusive lock on path, creating the file if needed. It fails straight
+// away rather than waiting when another process already holds the lock.
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening lock file: %v", err)
+	}
+
+	flags := uint32(windows.LOCKFILE_EXCLUSIVE_LOCK | windows.LOCKFILE_FAIL_IMMEDIATELY)
+	if err := windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, &windows.Overlapped{}); err != nil {
+		f.Close()
+		if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+			return nil, fmt.Errorf("another run is already in progress in this directory (%s is locked)", path)
+		}
+		return nil, fmt.Errorf("error locking %s: %v", path, err)
+	}
+	return f, nil
+}
+
+// unlockFile releases a lock taken by lockFile
+func unlockFile(f *os.File) error {
+	defer f.Close()
+	if err := windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, &windows.Overlapped{}); err != nil {
+		return fmt.Errorf("error unlocking %s: %v", f.Name(), err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package supplier
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ManifestFile is the default file recording each postcode file processed, so a run is
+// documented and files whose content changes are processed again
+const ManifestFile = "manifest.json"
+
+// ManifestEntry describes a processed postcode file
+type ManifestEntry struct {
+	File        string    `json:"file"`
+	SHA256      string    `json:"sha256"`
+	Rows        int       `json:"rows,omitempty"`         // Valid postcodes read from the file
+	CompletedAt time.Time `json:"completed_at,omitempty"` // When every postcode in it was done
+}
+
+// loadManifest loads the manifest in filename, keyed by file name
+func loadManifest(filename string) (map[string]ManifestEntry, error) {
+	manifest := make(map[string]ManifestEntry)
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return manifest, nil
+		}
+		return nil, fmt.Errorf("error reading manifest: %v", err)
+	}
+
+	var entries []ManifestEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("error parsing manifest: %v", err)
+	}
+	for _, entry := range entries {
+		manifest[entry.File] = entry
+	}
+	return manifest, nil
+}
+
+// saveManifest writes the manifest to filename, sorted by file name
+func saveManifest(manifest map[string]ManifestEntry, filename string) error {
+	entries := make([]ManifestEntry, 0, len(manifest))
+	for _, entry := range manifest {
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, func(a, b ManifestEntry) int { return strings.Compare(a.File, b.File) })
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling manifest: %v", err)
+	}
+
+	err = writeFileAtomic(filename, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error writing manifest: %v", err)
+	}
+	return nil
+}
+
+// fileChecksum returns the hex SHA-256 of the file's contents
+func fileChecksum(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", fmt.Errorf("error opening %s: %v", filename, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("error reading %s: %v", filename, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
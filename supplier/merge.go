@@ -0,0 +1,38 @@
+package supplier
+
+import (
+	"log/slog"
+	"sort"
+)
+
+// MergeResults combines result sets into one, keeping a single result per postcode sorted
+// by postcode. When a postcode appears more than once the result with the newest FetchedAt
+// wins, with later sets winning ties; differing supplier details are logged as conflicts.
+func MergeResults(sets ...[]PostcodeResult) []PostcodeResult {
+	merged := make(map[string]PostcodeResult)
+	for _, results := range sets {
+		for _, result := range results {
+			existing, ok := merged[result.Postcode]
+			if !ok {
+				merged[result.Postcode] = result
+				continue
+			}
+
+			if existing.Supplier != result.Supplier || existing.Phone != result.Phone || existing.Link != result.Link {
+				slog.Warn("Conflicting results for postcode", "postcode", result.Postcode,
+					"supplier", existing.Supplier, "fetched_at", formatFetchedAt(existing.FetchedAt),
+					"other_supplier", result.Supplier, "other_fetched_at", formatFetchedAt(result.FetchedAt))
+			}
+			if !result.FetchedAt.Before(existing.FetchedAt) {
+				merged[result.Postcode] = result
+			}
+		}
+	}
+
+	results := make([]PostcodeResult, 0, len(merged))
+	for _, result := range merged {
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Postcode < results[j].Postcode })
+	return results
+}
@@ -0,0 +1,87 @@
+package supplier
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMergeResults(t *testing.T) {
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(24 * time.Hour)
+
+	a := []PostcodeResult{
+		{Postcode: "SW1A 2AA", Supplier: "Thames Water", FetchedAt: newer},
+		{Postcode: "M1 1AE", Supplier: "United Utilities", FetchedAt: older},
+		{Postcode: "B33 8TH", Supplier: "Severn Trent", FetchedAt: older},
+	}
+	b := []PostcodeResult{
+		{Postcode: "SW1A 2AA", Supplier: "Affinity Water", FetchedAt: older},
+		{Postcode: "M1 1AE", Supplier: "United Utilities Water", FetchedAt: newer},
+		{Postcode: "B33 8TH", Supplier: "Severn Trent Water", FetchedAt: older},
+		{Postcode: "SW1A 1AA", Supplier: "Thames Water"},
+	}
+
+	got := MergeResults(a, b)
+	want := []PostcodeResult{
+		{Postcode: "B33 8TH", Supplier: "Severn Trent Water", FetchedAt: older},    // Later set wins a tie
+		{Postcode: "M1 1AE", Supplier: "United Utilities Water", FetchedAt: newer}, // Newer wins
+		{Postcode: "SW1A 1AA", Supplier: "Thames Water"},                           // Only in one set
+		{Postcode: "SW1A 2AA", Supplier: "Thames Water", FetchedAt: newer},         // Newer wins from the earlier set
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MergeResults() =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestMergeResultsEmpty(t *testing.T) {
+	if got := MergeResults(); got == nil || len(got) != 0 {
+		t.Errorf("MergeResults() = %#v, want empty", got)
+	}
+	if got := MergeResults(nil, []PostcodeResult{}); len(got) != 0 {
+		t.Errorf("MergeResults(nil, empty) = %+v, want empty", got)
+	}
+}
+
+func TestMergeResultsFiles(t *testing.T) {
+	dir := t.TempDir()
+	fetchedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	jsonFile := filepath.Join(dir, "a.json")
+	ndjsonFile := filepath.Join(dir, "b.ndjson")
+	merged := filepath.Join(dir, "merged.json")
+
+	err := SaveResultsFile([]PostcodeResult{{Postcode: "SW1A 1AA", Supplier: "Thames Water", Status: StatusFound, FetchedAt: fetchedAt}}, jsonFile)
+	if err != nil {
+		t.Fatalf("SaveResultsFile() error = %v", err)
+	}
+	err = SaveResultsFile([]PostcodeResult{{Postcode: "M1 1AE", Supplier: "United Utilities", Status: StatusFound, FetchedAt: fetchedAt}}, ndjsonFile)
+	if err != nil {
+		t.Fatalf("SaveResultsFile() error = %v", err)
+	}
+
+	// Merge results saved in different formats the way the merge command does
+	var sets [][]PostcodeResult
+	for _, filename := range []string{jsonFile, ndjsonFile} {
+		results, err := LoadResultsFile(filename)
+		if err != nil {
+			t.Fatalf("LoadResultsFile(%s) error = %v", filename, err)
+		}
+		sets = append(sets, results)
+	}
+	if err := SaveResultsFile(MergeResults(sets...), merged); err != nil {
+		t.Fatalf("SaveResultsFile() error = %v", err)
+	}
+
+	got, err := LoadResultsFile(merged)
+	if err != nil {
+		t.Fatalf("LoadResultsFile() error = %v", err)
+	}
+	want := []PostcodeResult{
+		{Postcode: "M1 1AE", Supplier: "United Utilities", Status: StatusFound, FetchedAt: fetchedAt},
+		{Postcode: "SW1A 1AA", Supplier: "Thames Water", Status: StatusFound, FetchedAt: fetchedAt},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("merged results =\n%+v\nwant\n%+v", got, want)
+	}
+}
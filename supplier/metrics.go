@@ -0,0 +1,119 @@
+package supplier
+
+import (
+	"expvar"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Keys of the expvar counters
+const (
+	metricRequests       = "requests"        // Lookup requests issued
+	metricInFlight       = "in_flight"       // Lookups currently being attempted
+	metricErrors         = "errors"          // Postcodes whose lookup failed
+	metricResultsWritten = "results_written" // Found and not-found results recorded
+)
+
+// Metrics are the counters of a run, for watching a long run through expvar and Prometheus.
+// Nothing is published globally: the program creating them chooses where they go. A nil
+// *Metrics records nothing.
+type Metrics struct {
+	vars *expvar.Map
+
+	requestsTotal   prometheus.Counter
+	lookupsTotal    *prometheus.CounterVec
+	retriesTotal    prometheus.Counter
+	requestDuration prometheus.Histogram
+}
+
+// NewMetrics creates the counters, registering the Prometheus ones with reg unless it is nil
+func NewMetrics(reg prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		vars: new(expvar.Map).Init(),
+		requestsTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "supplier_requests_total",
+			Help: "Lookup requests issued.",
+		}),
+		lookupsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "supplier_lookups_total",
+			Help: "Postcode lookups completed, by status (found, not_found, or the error).",
+		}, []string{"status"}),
+		retriesTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "supplier_retries_total",
+			Help: "Lookup attempts retried after a failure.",
+		}),
+		requestDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "supplier_request_duration_seconds",
+			Help:    "Latency of lookup requests.",
+			Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+		}),
+	}
+
+	// Start each counter at zero so they show up before use
+	for _, key := range []string{metricRequests, metricInFlight, metricErrors, metricResultsWritten} {
+		m.vars.Add(key, 0)
+	}
+
+	if reg != nil {
+		for _, collector := range []prometheus.Collector{m.requestsTotal, m.lookupsTotal, m.retriesTotal, m.requestDuration} {
+			if err := reg.Register(collector); err != nil {
+				return nil, fmt.Errorf("error registering metrics: %v", err)
+			}
+		}
+	}
+	return m, nil
+}
+
+// Vars returns the expvar counters, for publishing with expvar.Publish
+func (m *Metrics) Vars() *expvar.Map {
+	return m.vars
+}
+
+// requestDone records a lookup request that took d
+func (m *Metrics) requestDone(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.vars.Add(metricRequests, 1)
+	m.requestsTotal.Inc()
+	m.requestDuration.Observe(d.Seconds())
+}
+
+// attempting records a lookup attempt starting, with delta 1, or finishing, with delta -1
+func (m *Metrics) attempting(delta int64) {
+	if m == nil {
+		return
+	}
+	m.vars.Add(metricInFlight, delta)
+}
+
+// retried records a lookup attempt made after an earlier one failed
+func (m *Metrics) retried() {
+	if m == nil {
+		return
+	}
+	m.retriesTotal.Inc()
+}
+
+// lookupDone records a postcode lookup finishing with status
+func (m *Metrics) lookupDone(status LookupStatus) {
+	if m == nil {
+		return
+	}
+	m.lookupsTotal.WithLabelValues(string(status)).Inc()
+}
+
+// collected records a result collected by the run, counting it written when definitive
+// and as an error otherwise
+func (m *Metrics) collected(status LookupStatus) {
+	if m == nil {
+		return
+	}
+	if status.Definitive() {
+		m.vars.Add(metricResultsWritten, 1)
+	} else {
+		m.vars.Add(metricErrors, 1)
+	}
+}
@@ -0,0 +1,165 @@
+package supplier
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ndjsonResultsFile returns the file holding one JSON result per line when using the ndjson
+// format, named after the JSON results file output
+func ndjsonResultsFile(output string) string {
+	return resultsFileAs(output, ".ndjson")
+}
+
+// ndjsonWriter appends results to a newline-delimited JSON file as they complete,
+// so saving never has to re-marshal everything collected so far
+type ndjsonWriter struct {
+	file    *os.File
+	buf     *bufio.Writer
+	encoder *json.Encoder
+}
+
+// openNDJSON opens filename for appending, creating it if needed
+func openNDJSON(filename string) (*ndjsonWriter, error) {
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening NDJSON file: %v", err)
+	}
+
+	buf := bufio.NewWriter(file)
+	return &ndjsonWriter{file: file, buf: buf, encoder: json.NewEncoder(buf)}, nil
+}
+
+// Write buffers a single result as one line of JSON
+func (w *ndjsonWriter) Write(result PostcodeResult) error {
+	if err := w.encoder.Encode(result); err != nil {
+		return fmt.Errorf("error writing result for postcode %s: %v", result.Postcode, err)
+	}
+	return nil
+}
+
+// Flush writes any buffered results through to the file
+func (w *ndjsonWriter) Flush() error {
+	if err := w.buf.Flush(); err != nil {
+		return fmt.Errorf("error flushing NDJSON file: %v", err)
+	}
+	return nil
+}
+
+// Close flushes buffered results and closes the file
+func (w *ndjsonWriter) Close() error {
+	if err := w.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// loadNDJSONFetchTimes reads an NDJSON results file line by line and returns the postcodes
+// it contains with when each was fetched, without holding the full results in memory.
+// A postcode refetched in a later run appears again further down, so later lines win.
+func loadNDJSONFetchTimes(filename string) (map[string]time.Time, error) {
+	fetchTimes := make(map[string]time.Time)
+
+	file, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fetchTimes, nil
+		}
+		return nil, fmt.Errorf("error opening NDJSON file: %v", err)
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for {
+		var result struct {
+			Postcode  string    `json:"postcode"`
+			FetchedAt time.Time `json:"fetched_at"`
+		}
+		err := decoder.Decode(&result)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing NDJSON file: %v", err)
+		}
+		fetchTimes[result.Postcode] = result.FetchedAt
+	}
+
+	return fetchTimes, nil
+}
+
+// loadNDJSONResults reads every result from an NDJSON results file. A postcode refetched in
+// a later run appears again further down, so only its last result is kept. A missing file
+// is reported with an error satisfying os.IsNotExist.
+func loadNDJSONResults(filename string) ([]PostcodeResult, error) {
+	var results []PostcodeResult
+	index := make(map[string]int)
+	err := streamNDJSONResults(filename)(func(result PostcodeResult) error {
+		if i, ok := index[result.Postcode]; ok {
+			results[i] = result
+			return nil
+		}
+		index[result.Postcode] = len(results)
+		results = append(results, result)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// streamNDJSONResults returns a stream of every line of an NDJSON results file, including
+// each earlier result for a postcode that was refetched. A missing file is reported with an
+// error satisfying os.IsNotExist.
+func streamNDJSONResults(filename string) resultStream {
+	return func(fn func(PostcodeResult) error) error {
+		file, err := os.Open(filename)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return err
+			}
+			return fmt.Errorf("error opening NDJSON file: %v", err)
+		}
+		defer file.Close()
+
+		decoder := json.NewDecoder(bufio.NewReader(file))
+		for {
+			var result PostcodeResult
+			err := decoder.Decode(&result)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("error parsing NDJSON file: %v", err)
+			}
+			if err := fn(result); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// saveResultsToNDJSON rewrites filename with one JSON result per line from a stream
+func saveResultsToNDJSON(results resultStream, filename string) error {
+	err := writeFileAtomic(filename, func(w io.Writer) error {
+		buf := bufio.NewWriter(w)
+		encoder := json.NewEncoder(buf)
+		err := results(func(result PostcodeResult) error {
+			return encoder.Encode(result)
+		})
+		if err != nil {
+			return err
+		}
+		return buf.Flush()
+	})
+	if err != nil {
+		return fmt.Errorf("error writing to NDJSON file: %v", err)
+	}
+	return nil
+}
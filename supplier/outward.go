@@ -0,0 +1,59 @@
+package supplier
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// outwardResolver looks up one representative postcode per outward code (the part before
+// the space, such as "BS1") and applies its answer to every other postcode sharing it. This
+// is an approximation: suppliers usually, but not always, follow outward code boundaries.
+// It is safe for concurrent use.
+type outwardResolver struct {
+	mu      sync.Mutex
+	results map[string]PostcodeResult // Definitive answers by outward code
+	group   singleflight.Group        // Holds back postcodes whose representative is in flight
+}
+
+// newOutwardResolver creates a resolver with no outward codes looked up yet
+func newOutwardResolver() *outwardResolver {
+	return &outwardResolver{results: make(map[string]PostcodeResult)}
+}
+
+// lookup returns the result for postcode, calling fetch only when its outward code has no
+// answer yet, or its representative's lookup failed
+func (r *outwardResolver) lookup(ctx context.Context, postcode string, fetch func(context.Context, string) PostcodeResult) PostcodeResult {
+	outward, _, _ := strings.Cut(postcode, " ")
+
+	r.mu.Lock()
+	result, ok := r.results[outward]
+	r.mu.Unlock()
+
+	if !ok {
+		v, _, _ := r.group.Do(outward, func() (any, error) {
+			result := fetch(ctx, postcode)
+			if result.Status.Definitive() {
+				r.mu.Lock()
+				r.results[outward] = result
+				r.mu.Unlock()
+			}
+			return result, nil
+		})
+		result = v.(PostcodeResult)
+	}
+
+	switch {
+	case result.Postcode == postcode:
+		return result
+	case !result.Status.Definitive():
+		return fetch(ctx, postcode)
+	}
+
+	result.ApproximatedFrom = result.Postcode
+	result.Postcode = postcode
+	result.Attempts = 0
+	return result
+}
@@ -0,0 +1,55 @@
+package supplier
+
+import "strings"
+
+// normalizePhone canonicalizes a phone number so the same number always compares equal.
+// Punctuation and spacing are dropped, keeping a leading +. UK numbers, including those
+// given as +44, are rewritten in national format with the usual grouping: "020 7946 0000",
+// "0800 123 4567", "0121 496 0000", "01632 960000". Anything else is returned as just its
+// digits, and text without enough digits to be a number (e.g. "Not Found") is returned
+// unchanged.
+func normalizePhone(phone string) string {
+	var digits strings.Builder
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			digits.WriteRune(r)
+		}
+	}
+	number := digits.String()
+	if len(number) < 6 {
+		return phone
+	}
+
+	international := strings.HasPrefix(strings.TrimSpace(phone), "+")
+	if international {
+		if !strings.HasPrefix(number, "44") {
+			return "+" + number
+		}
+		number = "0" + strings.TrimPrefix(number, "44")
+	}
+
+	// A trunk 0 is sometimes kept after +44, as in "+44 (0)20 7946 0000"
+	if strings.HasPrefix(number, "00") {
+		number = number[1:]
+	}
+
+	switch {
+	case len(number) == 11 && strings.HasPrefix(number, "02"):
+		return number[:3] + " " + number[3:7] + " " + number[7:]
+	case len(number) == 11 && strings.HasPrefix(number, "01") && (number[2] == '1' || number[3] == '1'):
+		// Large cities have 3 digit area codes: 011x (Leeds, Sheffield...) and 01x1
+		// (Birmingham, Edinburgh, Glasgow, Liverpool, Manchester, Tyneside)
+		return number[:4] + " " + number[4:7] + " " + number[7:]
+	case len(number) == 11 && strings.HasPrefix(number, "01"):
+		return number[:5] + " " + number[5:]
+	case len(number) == 11 && strings.HasPrefix(number, "0"):
+		return number[:4] + " " + number[4:7] + " " + number[7:]
+	case len(number) == 10 && strings.HasPrefix(number, "0"):
+		return number[:4] + " " + number[4:]
+	}
+
+	if international {
+		return "+" + strings.TrimPrefix(number, "0")
+	}
+	return number
+}
@@ -0,0 +1,38 @@
+package supplier
+
+import "testing"
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"0800 316 9800", "0800 316 9800"},
+		{"0800-316-9800", "0800 316 9800"},
+		{"0800 1234 567", "0800 123 4567"},
+		{"(0800) 0778 778", "0800 077 8778"},
+		{"020 7946 0000", "020 7946 0000"},
+		{"02079460000", "020 7946 0000"},
+		{"01225 526 000", "01225 526000"},
+		{"+44 1225 526000", "01225 526000"},
+		{"0121 496 0000", "0121 496 0000"},
+		{"01214960000", "0121 496 0000"},
+		{"0131-496-0000", "0131 496 0000"},
+		{"+44 161 496 0000", "0161 496 0000"},
+		{"0113 496 0000", "0113 496 0000"},
+		{"01632 960000", "01632 960000"},
+		{"+44 (0)20 7946 0000", "020 7946 0000"},
+		{"0345 357 2407", "0345 357 2407"},
+		{"0800 123456", "0800 123456"},
+		{"+353 1 234 5678", "+35312345678"},
+		{"+44 123", "+44 123"},
+		{"Not Found", "Not Found"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := normalizePhone(tt.in); got != tt.want {
+			t.Errorf("normalizePhone(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
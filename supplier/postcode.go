@@ -0,0 +1,26 @@
+package supplier
+
+import (
+	"regexp"
+	"strings"
+)
+
+// postcodePattern matches a normalized UK postcode: outward code, a single space, inward code
+var postcodePattern = regexp.MustCompile(`^(GIR 0AA|[A-Z]{1,2}[0-9][A-Z0-9]? [0-9][A-Z]{2})$`)
+
+// normalizePostcode uppercases a postcode, collapses its whitespace into the single space
+// separating the outward and inward codes, and validates it. ok is false for anything that
+// isn't a valid UK postcode.
+func normalizePostcode(postcode string) (string, bool) {
+	compact := strings.ToUpper(strings.Join(strings.Fields(postcode), ""))
+	if len(compact) < 5 {
+		return "", false
+	}
+
+	// The inward code is always the final three characters
+	normalized := compact[:len(compact)-3] + " " + compact[len(compact)-3:]
+	if !postcodePattern.MatchString(normalized) {
+		return "", false
+	}
+	return normalized, true
+}
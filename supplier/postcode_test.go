@@ -0,0 +1,35 @@
+package supplier
+
+import "testing"
+
+func TestNormalizePostcode(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   string
+		wantOK bool
+	}{
+		{"SW1A 1AA", "SW1A 1AA", true},
+		{"sw1a1aa", "SW1A 1AA", true},
+		{"  SW1A   1AA ", "SW1A 1AA", true},
+		{"M1 1AE", "M1 1AE", true},
+		{"b338th", "B33 8TH", true},
+		{"CR2 6XH", "CR2 6XH", true},
+		{"DN55 1PT", "DN55 1PT", true},
+		{"EC1A\t1BB", "EC1A 1BB", true},
+		{"gir0aa", "GIR 0AA", true},
+		{"", "", false},
+		{"SW1", "", false},
+		{"postcode", "", false},
+		{"12345", "", false},
+		{"SW1A 1A1", "", false},
+		{"SW1A 1AAA", "", false},
+		{"Q1 1AA1", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := normalizePostcode(tt.in)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("normalizePostcode(%q) = %q, %v, want %q, %v", tt.in, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
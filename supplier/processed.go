@@ -0,0 +1,101 @@
+package supplier
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// postcodeSet is the set of postcodes that already have a result, with when each was
+// fetched, shared between the goroutine queueing lookups and the one collecting results.
+// It is safe for concurrent use.
+type postcodeSet struct {
+	mu        sync.RWMutex
+	postcodes map[string]time.Time
+
+	// index, when set, holds the postcodes processed by earlier runs on disk, leaving only
+	// those added since in postcodes
+	index *postcodeIndex
+
+	// staleBefore, when set, makes results fetched before it count as unprocessed so
+	// they are looked up again
+	staleBefore time.Time
+}
+
+// newPostcodeSet creates an empty set in which results fetched before staleBefore are
+// treated as unprocessed; a zero staleBefore keeps every result
+func newPostcodeSet(staleBefore time.Time) *postcodeSet {
+	return &postcodeSet{postcodes: make(map[string]time.Time), staleBefore: staleBefore}
+}
+
+// Add records postcode as processed with a result fetched at fetchedAt
+func (s *postcodeSet) Add(postcode string, fetchedAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.postcodes[postcode] = fetchedAt
+}
+
+// Has reports whether postcode has a result recent enough to skip. Results without a
+// timestamp predate timestamps, so are always stale when refetching.
+func (s *postcodeSet) Has(postcode string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fetchedAt, ok := s.postcodes[postcode]
+	if !ok && s.index != nil {
+		var err error
+		fetchedAt, ok, err = s.index.lookup(postcode)
+		if err != nil {
+			slog.Warn("Error checking processed index", "postcode", postcode, "err", err)
+		}
+	}
+	if !ok {
+		return false
+	}
+	return s.staleBefore.IsZero() || !fetchedAt.Before(s.staleBefore)
+}
+
+// saveIndex writes every postcode in the set to the on-disk index at path and switches the
+// set over to it, leaving nothing held in memory
+func (s *postcodeSet) saveIndex(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := writePostcodeIndex(path, s.index, s.postcodes); err != nil {
+		return err
+	}
+	index, err := openPostcodeIndex(path)
+	if err != nil {
+		return err
+	}
+
+	if s.index != nil {
+		s.index.Close()
+	}
+	s.index = index
+	s.postcodes = make(map[string]time.Time)
+	return nil
+}
+
+// useIndex switches the set over to the on-disk index at path for postcodes from earlier
+// runs
+func (s *postcodeSet) useIndex(path string) error {
+	index, err := openPostcodeIndex(path)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.index = index
+	return nil
+}
+
+// Close closes the set's on-disk index, if any
+func (s *postcodeSet) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.index == nil {
+		return nil
+	}
+	return s.index.Close()
+}
@@ -0,0 +1,330 @@
+package supplier
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProgressFile is the default file recording where processing got to, so an interrupted
+// run can resume
+const ProgressFile = "progress.json"
+
+// lockFilename returns the lock held for the whole of a run writing filename, the progress
+// or results, so two runs can't clobber each other's saves
+func lockFilename(filename string) string {
+	return filename + ".lock"
+}
+
+// Progress tracks the current state of processing
+type Progress struct {
+	CompletedFiles []string                `json:"completed_files,omitempty"` // CSV files whose postcodes have all been processed
+	Files          map[string]FileProgress `json:"files,omitempty"`           // Postcodes processed in files still in progress
+	Completed      bool                    `json:"completed"`                 // Whether all processing is complete
+
+	// Progress files from before per-file tracking only record the last postcode processed
+	LastFile     string `json:"last_file,omitempty"`     // Last CSV file processed
+	LastPostcode string `json:"last_postcode,omitempty"` // Last postcode processed
+}
+
+// FileProgress records the processed postcodes of a file still in progress
+type FileProgress struct {
+	Done      int      `json:"done"`                // Number of postcodes processed from the start of the file
+	Completed []string `json:"completed,omitempty"` // Postcodes after those processed out of order
+}
+
+// loadProgress loads the current progress from filename
+func loadProgress(filename string) (*Progress, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// If file doesn't exist, return new progress
+			return &Progress{}, nil
+		}
+		return nil, fmt.Errorf("error reading progress file: %v", err)
+	}
+
+	var progress Progress
+	if err := json.Unmarshal(data, &progress); err != nil {
+		return nil, fmt.Errorf("error parsing progress file: %v", err)
+	}
+
+	return &progress, nil
+}
+
+// saveProgress saves the current progress to filename
+func saveProgress(progress *Progress, filename string) error {
+	data, err := json.MarshalIndent(progress, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling progress: %v", err)
+	}
+
+	err = writeFileAtomic(filename, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error writing progress file: %v", err)
+	}
+
+	return nil
+}
+
+// progressTracker records completed postcodes across the files being processed, saving
+// for each file how many postcodes from its start are done plus any completed out of order,
+// so workers finishing out of order (or in different files) resume precisely without
+// redoing or skipping postcodes. Completions are saved on the same saveEvery/saveInterval
+// cadence as results, and files starting or finishing straight away. It is safe for
+// concurrent use.
+type progressTracker struct {
+	mu       sync.Mutex
+	progress *Progress
+	filename string                   // Where progress is saved
+	files    map[string]*fileProgress // Files with postcodes still in flight
+	unsaved  int                      // Completions recorded since progress was last saved
+	lastSave time.Time
+
+	// onFileComplete, when set, is called with the tracker locked as each file completes
+	onFileComplete func(filename string, postcodes int)
+}
+
+// fileProgress tracks the completed postcodes of a single file
+type fileProgress struct {
+	postcodes []string
+	next      int          // Index of the first postcode not yet completed
+	completed map[int]bool // Completed postcodes after next
+}
+
+// newProgressTracker creates a tracker that records into progress, saving it to filename
+func newProgressTracker(progress *Progress, filename string) *progressTracker {
+	return &progressTracker{progress: progress, filename: filename, files: make(map[string]*fileProgress), lastSave: time.Now()}
+}
+
+// addFile registers a file whose processing resumes at index start, with the postcodes in
+// done already completed. Files must be added before any of their postcodes are completed.
+func (t *progressTracker) addFile(filename string, postcodes []string, start int, done map[int]bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	f := &fileProgress{postcodes: postcodes, next: start, completed: make(map[int]bool)}
+	for idx := range done {
+		f.completed[idx] = true
+	}
+	t.files[filename] = f
+
+	// Once files are tracked individually the old single resume point no longer applies
+	t.progress.LastFile, t.progress.LastPostcode = "", ""
+	t.update(filename, f)
+	return t.save()
+}
+
+// complete marks the postcodes at indexes in filename as done, saving progress once
+// saveEvery postcodes have completed or saveInterval has passed since the last save
+func (t *progressTracker) complete(filename string, indexes ...int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	f, ok := t.files[filename]
+	if !ok || len(indexes) == 0 {
+		return nil
+	}
+	for _, idx := range indexes {
+		if idx >= f.next {
+			f.completed[idx] = true
+		}
+	}
+	t.unsaved += len(indexes)
+	if t.update(filename, f) {
+		// Saved straight away, so the manifest never lists a file that progress doesn't
+		return t.save()
+	}
+	if t.unsaved < saveEvery && time.Since(t.lastSave) < saveInterval {
+		return nil
+	}
+	return t.save()
+}
+
+// flush saves any completions not yet saved
+func (t *progressTracker) flush() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.unsaved == 0 {
+		return nil
+	}
+	return t.save()
+}
+
+// save records the progress of every file still in flight and writes it to disk. The
+// tracker must be locked.
+func (t *progressTracker) save() error {
+	for filename, f := range t.files {
+		completed := make([]string, 0, len(f.completed))
+		for idx := range f.completed {
+			completed = append(completed, f.postcodes[idx])
+		}
+		sort.Strings(completed)
+
+		if t.progress.Files == nil {
+			t.progress.Files = make(map[string]FileProgress)
+		}
+		t.progress.Files[filename] = FileProgress{Done: f.next, Completed: completed}
+	}
+
+	if err := saveProgress(t.progress, t.filename); err != nil {
+		return err
+	}
+	t.unsaved = 0
+	t.lastSave = time.Now()
+	return nil
+}
+
+// update moves filename past its completed postcodes, and to the completed files once all
+// of them are done, reporting whether it did
+func (t *progressTracker) update(filename string, f *fileProgress) bool {
+	for f.completed[f.next] {
+		delete(f.completed, f.next)
+		f.next++
+	}
+
+	if f.next >= len(f.postcodes) {
+		delete(t.files, filename)
+		t.progress.markCompleted(filename)
+		if t.onFileComplete != nil {
+			t.onFileComplete(filename, len(f.postcodes))
+		}
+		return true
+	}
+	return false
+}
+
+// writeFileAtomic writes a file via a temporary file in the same directory that is renamed
+// into place once complete, so a crash mid-write never leaves a truncated file behind
+func writeFileAtomic(filename string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file: %v", err)
+	}
+	// Clean up the temporary file on any failure; after the rename this is a no-op
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error syncing temporary file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error closing temporary file: %v", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("error setting file permissions: %v", err)
+	}
+
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return fmt.Errorf("error replacing %s: %v", filename, err)
+	}
+	return nil
+}
+
+// clone returns a copy of p that later changes to p don't affect
+func (p *Progress) clone() *Progress {
+	c := *p
+	c.CompletedFiles = slices.Clone(p.CompletedFiles)
+	c.Files = maps.Clone(p.Files)
+	return &c
+}
+
+// migrate converts a progress file from before per-file tracking, marking the files
+// sorted before its last file as completed. The last file itself resumes after its last
+// postcode.
+func (p *Progress) migrate(files []string) {
+	if p.LastFile == "" {
+		return
+	}
+	idx := slices.IndexFunc(files, func(file string) bool { return filepath.Base(file) == p.LastFile })
+	for _, file := range files[:max(idx, 0)] {
+		if name := filepath.Base(file); !slices.Contains(p.CompletedFiles, name) {
+			p.CompletedFiles = append(p.CompletedFiles, name)
+		}
+	}
+}
+
+// resetFile forgets all progress through filename, so it is processed from the start
+func (p *Progress) resetFile(filename string) {
+	p.CompletedFiles = slices.DeleteFunc(p.CompletedFiles, func(name string) bool { return name == filename })
+	delete(p.Files, filename)
+	if p.LastFile == filename {
+		p.LastFile, p.LastPostcode = "", ""
+	}
+}
+
+// markCompleted records every postcode in filename as processed
+func (p *Progress) markCompleted(filename string) {
+	delete(p.Files, filename)
+	if !slices.Contains(p.CompletedFiles, filename) {
+		p.CompletedFiles = append(p.CompletedFiles, filename)
+	}
+}
+
+// fileCompleted reports whether every postcode in filename has been processed
+func (p *Progress) fileCompleted(filename string) bool {
+	return slices.Contains(p.CompletedFiles, filename)
+}
+
+// resumePoint returns where to resume processing filename: the index of the first postcode
+// not yet processed, and the indexes of postcodes after it that were processed out of order
+func resumePoint(progress *Progress, filename string, postcodes []string) (start int, done map[int]bool) {
+	if fp, ok := progress.Files[filename]; ok {
+		start = min(fp.Done, len(postcodes))
+		completed := make(map[string]bool, len(fp.Completed))
+		for _, pc := range fp.Completed {
+			completed[resumeKey(pc)] = true
+		}
+		done = make(map[int]bool, len(completed))
+		for j := start; j < len(postcodes); j++ {
+			if completed[resumeKey(postcodes[j])] {
+				done[j] = true
+			}
+		}
+		return start, done
+	}
+
+	if filename != progress.LastFile || progress.LastPostcode == "" {
+		return 0, nil
+	}
+	last := resumeKey(progress.LastPostcode)
+	for j, pc := range postcodes {
+		if resumeKey(pc) == last {
+			return j + 1, nil // Start from the NEXT postcode
+		}
+	}
+	return 0, nil
+}
+
+// resumeKey returns the form postcodes are compared in when resuming, so a progress file
+// written before a change to how postcodes are read still matches: HTML entities and
+// percent-encoding are decoded before normalizing, and anything that still isn't a valid
+// postcode is compared trimmed and uppercased
+func resumeKey(postcode string) string {
+	decoded := html.UnescapeString(postcode)
+	if unescaped, err := url.QueryUnescape(decoded); err == nil {
+		decoded = unescaped
+	}
+	if normalized, ok := normalizePostcode(decoded); ok {
+		return normalized
+	}
+	return strings.ToUpper(strings.TrimSpace(postcode))
+}
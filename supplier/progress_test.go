@@ -0,0 +1,261 @@
+package supplier
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestProgressTrackerOutOfOrder(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), ProgressFile)
+	postcodes := []string{"SW1A 1AA", "SW1A 2AA", "M1 1AE", "B33 8TH"}
+
+	progress := &Progress{}
+	tracker := newProgressTracker(progress, filename)
+	var completedFiles []string
+	tracker.onFileComplete = func(name string, n int) { completedFiles = append(completedFiles, name) }
+
+	if err := tracker.addFile("a.csv", postcodes, 0, nil); err != nil {
+		t.Fatalf("addFile() error = %v", err)
+	}
+
+	steps := []struct {
+		idx  int
+		want FileProgress
+	}{
+		{2, FileProgress{Done: 0, Completed: []string{"M1 1AE"}}},
+		{1, FileProgress{Done: 0, Completed: []string{"M1 1AE", "SW1A 2AA"}}},
+		{0, FileProgress{Done: 3}},
+	}
+	for _, step := range steps {
+		if err := tracker.complete("a.csv", step.idx); err != nil {
+			t.Fatalf("complete(%d) error = %v", step.idx, err)
+		}
+		if err := tracker.flush(); err != nil {
+			t.Fatalf("flush() error = %v", err)
+		}
+		saved, err := loadProgress(filename)
+		if err != nil {
+			t.Fatalf("loadProgress() error = %v", err)
+		}
+		if got := saved.Files["a.csv"]; !reflect.DeepEqual(got, step.want) {
+			t.Errorf("after complete(%d) saved progress = %+v, want %+v", step.idx, got, step.want)
+		}
+	}
+
+	if err := tracker.complete("a.csv", 3); err != nil {
+		t.Fatalf("complete(3) error = %v", err)
+	}
+	saved, err := loadProgress(filename)
+	if err != nil {
+		t.Fatalf("loadProgress() error = %v", err)
+	}
+	if !saved.fileCompleted("a.csv") || len(saved.Files) != 0 {
+		t.Errorf("saved progress = %+v, want a.csv completed", saved)
+	}
+	if !reflect.DeepEqual(completedFiles, []string{"a.csv"}) {
+		t.Errorf("onFileComplete called for %v, want [a.csv]", completedFiles)
+	}
+}
+
+func TestProgressTrackerResume(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), ProgressFile)
+	postcodes := []string{"SW1A 1AA", "SW1A 2AA", "M1 1AE", "B33 8TH"}
+
+	tracker := newProgressTracker(&Progress{}, filename)
+	if err := tracker.addFile("a.csv", postcodes, 0, nil); err != nil {
+		t.Fatalf("addFile() error = %v", err)
+	}
+	for _, idx := range []int{0, 2} {
+		if err := tracker.complete("a.csv", idx); err != nil {
+			t.Fatalf("complete(%d) error = %v", idx, err)
+		}
+	}
+	if err := tracker.flush(); err != nil {
+		t.Fatalf("flush() error = %v", err)
+	}
+
+	// A later run reading the saved progress picks up the gap and the out of order postcode
+	saved, err := loadProgress(filename)
+	if err != nil {
+		t.Fatalf("loadProgress() error = %v", err)
+	}
+	start, done := resumePoint(saved, "a.csv", postcodes)
+	if start != 1 || !reflect.DeepEqual(done, map[int]bool{2: true}) {
+		t.Fatalf("resumePoint() = %d, %v, want 1, map[2:true]", start, done)
+	}
+
+	tracker = newProgressTracker(saved, filename)
+	if err := tracker.addFile("a.csv", postcodes, start, done); err != nil {
+		t.Fatalf("addFile() error = %v", err)
+	}
+	for _, idx := range []int{3, 1} {
+		if err := tracker.complete("a.csv", idx); err != nil {
+			t.Fatalf("complete(%d) error = %v", idx, err)
+		}
+	}
+	if !saved.fileCompleted("a.csv") {
+		t.Errorf("progress = %+v, want a.csv completed", saved)
+	}
+}
+
+func TestProgressTrackerBatchesSaves(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), ProgressFile)
+	postcodes := make([]string, saveEvery*2)
+	for i := range postcodes {
+		postcodes[i] = fmt.Sprintf("SW1A %dAA", i)
+	}
+
+	tracker := newProgressTracker(&Progress{}, filename)
+	if err := tracker.addFile("a.csv", postcodes, 0, nil); err != nil {
+		t.Fatalf("addFile() error = %v", err)
+	}
+	savedDone := func() int {
+		t.Helper()
+		saved, err := loadProgress(filename)
+		if err != nil {
+			t.Fatalf("loadProgress() error = %v", err)
+		}
+		return saved.Files["a.csv"].Done
+	}
+
+	for idx := range saveEvery - 1 {
+		if err := tracker.complete("a.csv", idx); err != nil {
+			t.Fatalf("complete(%d) error = %v", idx, err)
+		}
+	}
+	if got := savedDone(); got != 0 {
+		t.Errorf("saved Done = %d after %d completions, want 0 until %d", got, saveEvery-1, saveEvery)
+	}
+	if err := tracker.complete("a.csv", saveEvery-1); err != nil {
+		t.Fatalf("complete(%d) error = %v", saveEvery-1, err)
+	}
+	if got := savedDone(); got != saveEvery {
+		t.Errorf("saved Done = %d after %d completions, want %d", got, saveEvery, saveEvery)
+	}
+
+	// Finishing the file saves straight away
+	for idx := len(postcodes) - 1; idx >= saveEvery; idx-- {
+		if err := tracker.complete("a.csv", idx); err != nil {
+			t.Fatalf("complete(%d) error = %v", idx, err)
+		}
+	}
+	saved, err := loadProgress(filename)
+	if err != nil {
+		t.Fatalf("loadProgress() error = %v", err)
+	}
+	if !saved.fileCompleted("a.csv") {
+		t.Errorf("saved progress = %+v, want a.csv completed", saved)
+	}
+}
+
+func TestProgressTrackerCompletesInBulk(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), ProgressFile)
+	postcodes := make([]string, saveEvery*2)
+	indexes := make([]int, 0, len(postcodes))
+	for i := range postcodes {
+		postcodes[i] = fmt.Sprintf("SW1A %dAA", i)
+		indexes = append(indexes, i)
+	}
+
+	tracker := newProgressTracker(&Progress{}, filename)
+	if err := tracker.addFile("a.csv", postcodes, 0, nil); err != nil {
+		t.Fatalf("addFile() error = %v", err)
+	}
+	// A run of skipped postcodes is saved together, including ones completed out of order
+	skipped := append(slices.Clone(indexes[:saveEvery]), saveEvery+1)
+	if err := tracker.complete("a.csv", skipped...); err != nil {
+		t.Fatalf("complete(%v) error = %v", skipped, err)
+	}
+	saved, err := loadProgress(filename)
+	if err != nil {
+		t.Fatalf("loadProgress() error = %v", err)
+	}
+	want := FileProgress{Done: saveEvery, Completed: []string{postcodes[saveEvery+1]}}
+	if got := saved.Files["a.csv"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("saved progress = %+v, want %+v", got, want)
+	}
+
+	if err := tracker.complete("a.csv", indexes...); err != nil {
+		t.Fatalf("complete(all) error = %v", err)
+	}
+	if saved, _ := loadProgress(filename); !saved.fileCompleted("a.csv") {
+		t.Errorf("saved progress = %+v, want a.csv completed", saved)
+	}
+}
+
+func TestProgressMigrate(t *testing.T) {
+	files := []string{"in/a.csv", "in/b.csv", "in/c.csv", "in/d.csv"}
+	progress := &Progress{CompletedFiles: []string{"a.csv"}, LastFile: "c.csv", LastPostcode: "sw1a2aa"}
+	progress.migrate(files)
+
+	if want := []string{"a.csv", "b.csv"}; !reflect.DeepEqual(progress.CompletedFiles, want) {
+		t.Errorf("CompletedFiles = %v, want %v", progress.CompletedFiles, want)
+	}
+
+	// The last file resumes after its last postcode, matched however it was written
+	postcodes := []string{"SW1A 1AA", "SW1A 2AA", "M1 1AE"}
+	if start, done := resumePoint(progress, "c.csv", postcodes); start != 2 || done != nil {
+		t.Errorf("resumePoint(c.csv) = %d, %v, want 2, nil", start, done)
+	}
+	if start, _ := resumePoint(progress, "d.csv", postcodes); start != 0 {
+		t.Errorf("resumePoint(d.csv) = %d, want 0", start)
+	}
+
+	// Tracking a file individually drops the old single resume point
+	tracker := newProgressTracker(progress, filepath.Join(t.TempDir(), ProgressFile))
+	if err := tracker.addFile("c.csv", postcodes, 2, nil); err != nil {
+		t.Fatalf("addFile() error = %v", err)
+	}
+	if progress.LastFile != "" || progress.LastPostcode != "" {
+		t.Errorf("LastFile, LastPostcode = %q, %q, want both cleared", progress.LastFile, progress.LastPostcode)
+	}
+}
+
+func TestResumePointAcrossNormalization(t *testing.T) {
+	postcodes := []string{"SW1A 1AA", "SW1A 2AA", "M1 1AE", "B33 8TH"}
+	tests := []struct {
+		name     string
+		progress Progress
+		want     int
+	}{
+		{"lowercase last postcode", Progress{LastFile: "a.csv", LastPostcode: "sw1a 2aa"}, 2},
+		{"unspaced last postcode", Progress{LastFile: "a.csv", LastPostcode: "M11AE"}, 3},
+		{"percent-encoded last postcode", Progress{LastFile: "a.csv", LastPostcode: "SW1A%202AA"}, 2},
+		{"lowercase completed postcode", Progress{Files: map[string]FileProgress{"a.csv": {Done: 1, Completed: []string{"m1 1ae"}}}}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, done := resumePoint(&tt.progress, "a.csv", postcodes)
+			if start != tt.want {
+				t.Errorf("resumePoint() start = %d, want %d", start, tt.want)
+			}
+			if tt.progress.Files != nil && !reflect.DeepEqual(done, map[int]bool{2: true}) {
+				t.Errorf("resumePoint() done = %v, want map[2:true]", done)
+			}
+		})
+	}
+}
+
+func TestResumeKey(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"SW1A 1AA", "SW1A 1AA"},
+		{"sw1a1aa", "SW1A 1AA"},
+		{"SW1A%201AA", "SW1A 1AA"},
+		{"SW1A+1AA", "SW1A 1AA"},
+		{"SW1A&#32;1AA", "SW1A 1AA"},
+		{" not a postcode ", "NOT A POSTCODE"},
+	}
+
+	for _, tt := range tests {
+		if got := resumeKey(tt.in); got != tt.want {
+			t.Errorf("resumeKey(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package supplier
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// SupplierCount is the number of postcodes served by a supplier
+type SupplierCount struct {
+	Supplier  string `json:"supplier"`
+	Postcodes int    `json:"postcodes"`
+}
+
+// CountSuppliers tallies the postcodes per water supplier, sorted by descending count and
+// then by name. Postcodes without a supplier are left out.
+func CountSuppliers(results []PostcodeResult) []SupplierCount {
+	counts := make(map[string]int)
+	for _, result := range results {
+		if result.Supplier == "" || result.Supplier == "Not Found" {
+			continue
+		}
+		counts[result.Supplier]++
+	}
+
+	report := make([]SupplierCount, 0, len(counts))
+	for supplier, n := range counts {
+		report = append(report, SupplierCount{Supplier: supplier, Postcodes: n})
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Postcodes != report[j].Postcodes {
+			return report[i].Postcodes > report[j].Postcodes
+		}
+		return report[i].Supplier < report[j].Supplier
+	})
+	return report
+}
+
+// WriteSupplierReport writes the counts as an aligned text table
+func WriteSupplierReport(w io.Writer, report []SupplierCount) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SUPPLIER\tPOSTCODES")
+	for _, entry := range report {
+		fmt.Fprintf(tw, "%s\t%d\n", entry.Supplier, entry.Postcodes)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,70 @@
+package supplier
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestCountSuppliers(t *testing.T) {
+	results := []PostcodeResult{
+		{Postcode: "SW1A 1AA", Supplier: "Thames Water"},
+		{Postcode: "SW1A 2AA", Supplier: "Thames Water"},
+		{Postcode: "M1 1AE", Supplier: "United Utilities"},
+		{Postcode: "B33 8TH", Supplier: "Severn Trent"},
+		{Postcode: "ZE3 9JZ", Supplier: "Not Found"},
+		{Postcode: "GIR 0AA"},
+	}
+
+	got := CountSuppliers(results)
+	want := []SupplierCount{
+		{Supplier: "Thames Water", Postcodes: 2},
+		{Supplier: "Severn Trent", Postcodes: 1},
+		{Supplier: "United Utilities", Postcodes: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CountSuppliers() = %+v, want %+v", got, want)
+	}
+
+	if got := CountSuppliers(nil); got == nil || len(got) != 0 {
+		t.Errorf("CountSuppliers(nil) = %#v, want empty", got)
+	}
+}
+
+func TestWriteSupplierReport(t *testing.T) {
+	tests := []struct {
+		name   string
+		report []SupplierCount
+		want   string
+	}{
+		{
+			name:   "empty",
+			report: nil,
+			want:   "SUPPLIER  POSTCODES\n",
+		},
+		{
+			name: "aligned columns",
+			report: []SupplierCount{
+				{Supplier: "Thames Water", Postcodes: 1200},
+				{Supplier: "Severn Trent", Postcodes: 35},
+				{Supplier: "Bristol Water", Postcodes: 4},
+			},
+			want: "SUPPLIER       POSTCODES\n" +
+				"Thames Water   1200\n" +
+				"Severn Trent   35\n" +
+				"Bristol Water  4\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteSupplierReport(&buf, tt.report); err != nil {
+				t.Fatalf("WriteSupplierReport() error = %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("WriteSupplierReport() wrote\n%s\nwant\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+}
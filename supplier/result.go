@@ -0,0 +1,108 @@
+package supplier
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// PostcodeResult holds the result for each postcode lookup
+type PostcodeResult struct {
+	Postcode string `json:"postcode"`
+	Supplier string `json:"supplier"`
+	Phone    string `json:"phone"`
+	Link     string `json:"link"`
+	Email    string `json:"email,omitempty"`
+	Address  string `json:"address,omitempty"`
+
+	// Sewerage supplier details, only set when the postcode has a separate waste water supplier
+	SewerageSupplier string `json:"sewerage_supplier,omitempty"`
+	SeweragePhone    string `json:"sewerage_phone,omitempty"`
+	SewerageLink     string `json:"sewerage_link,omitempty"`
+	SewerageEmail    string `json:"sewerage_email,omitempty"`
+	SewerageAddress  string `json:"sewerage_address,omitempty"`
+
+	// LinkTitle is the page title of the supplier's site at Link, only set when enriching
+	LinkTitle string `json:"link_title,omitempty"`
+
+	// FetchedAt is when the lookup completed, kept from earlier runs when resuming
+	FetchedAt time.Time `json:"fetched_at"`
+
+	// Diagnostics for the last attempt: the HTTP status (zero if no response was received),
+	// how many attempts were made, and why the lookup failed (empty on success)
+	HTTPStatus int    `json:"http_status,omitempty"`
+	Attempts   int    `json:"attempts,omitempty"`
+	Error      string `json:"error,omitempty"`
+
+	// Status is the outcome of the lookup
+	Status LookupStatus `json:"status,omitempty"`
+
+	// ApproximatedFrom is the postcode actually looked up when this result was copied from
+	// another in the same outward code, rather than looked up itself
+	ApproximatedFrom string `json:"approximated_from,omitempty"`
+
+	// RetryAfter holds the wait requested by the server when rate limited
+	RetryAfter time.Duration `json:"-"`
+}
+
+// LookupStatus classifies the outcome of a postcode lookup
+type LookupStatus string
+
+const (
+	StatusFound        LookupStatus = "found"         // A supplier was found
+	StatusNotFound     LookupStatus = "not_found"     // A valid response with no supplier, so no coverage
+	StatusNetworkError LookupStatus = "network_error" // The request failed or got a bad status
+	StatusParseError   LookupStatus = "parse_error"   // The response couldn't be parsed
+	StatusRateLimited  LookupStatus = "rate_limited"  // The server answered 429
+)
+
+// Definitive reports whether the status is a real answer worth keeping, as opposed to an
+// error that should be looked up again
+func (s LookupStatus) Definitive() bool {
+	return s == StatusFound || s == StatusNotFound
+}
+
+// lookupJob is a single postcode queued for lookup, with its position in its source file
+type lookupJob struct {
+	file     string
+	index    int
+	postcode string
+}
+
+// AjaxResponse represents the structure of the JSON response
+type AjaxResponse struct {
+	Data string `json:"data"`
+}
+
+// ErrSchemaChanged reports a response that is valid JSON but not the array of Drupal AJAX
+// commands the scraper expects, meaning the site has changed
+var ErrSchemaChanged = errors.New("response schema changed")
+
+// parseAjaxResponse parses a form submission response, checking it is a non-empty array of
+// command objects so a changed site is reported as ErrSchemaChanged rather than read as
+// empty results
+func parseAjaxResponse(body []byte) ([]AjaxResponse, error) {
+	var commands []map[string]json.RawMessage
+	if err := json.Unmarshal(body, &commands); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return nil, fmt.Errorf("error parsing JSON response: %v", err)
+		}
+		return nil, fmt.Errorf("%w: expected an array of command objects: %v", ErrSchemaChanged, err)
+	}
+	if len(commands) == 0 {
+		return nil, fmt.Errorf("%w: no commands in response", ErrSchemaChanged)
+	}
+	for i, command := range commands {
+		if _, ok := command["command"]; !ok {
+			return nil, fmt.Errorf("%w: command %d has no command name", ErrSchemaChanged, i)
+		}
+	}
+
+	var ajaxResponse []AjaxResponse
+	if err := json.Unmarshal(body, &ajaxResponse); err != nil {
+		return nil, fmt.Errorf("%w: unexpected command data: %v", ErrSchemaChanged, err)
+	}
+	return ajaxResponse, nil
+}
@@ -0,0 +1,426 @@
+package supplier
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ResultsFile is the default JSON results file
+const ResultsFile = "water_suppliers_results.json"
+
+// csvResultsFile returns the file receiving results when saving in CSV format, named after
+// the JSON results file output
+func csvResultsFile(output string) string {
+	return resultsFileAs(output, ".csv")
+}
+
+// resultsFileAs returns output with its extension, including any .gz, replaced by ext
+func resultsFileAs(output, ext string) string {
+	output = strings.TrimSuffix(output, ".gz")
+	return strings.TrimSuffix(output, filepath.Ext(output)) + ext
+}
+
+// loadExistingResults loads any existing results saved in format, from the file named by
+// existingResultsFile
+func loadExistingResults(format, output string) ([]PostcodeResult, error) {
+	filename, err := existingResultsFile(format, output)
+	if err != nil {
+		return nil, err
+	}
+	if filename == "" {
+		return []PostcodeResult{}, nil
+	}
+	return LoadResultsFile(filename)
+}
+
+// existingResultsFile returns the file holding results already saved in format: the JSON
+// results file output, or the CSV file named after it for the csv format, which writes no
+// JSON. It returns an empty name when nothing has been saved yet.
+func existingResultsFile(format, output string) (string, error) {
+	candidates := []string{csvResultsFile(output)}
+	if format != "csv" {
+		// Pick up results saved before output compression was switched on or off
+		candidates = []string{output, compressionSibling(output)}
+	}
+	for _, filename := range candidates {
+		_, err := os.Stat(filename)
+		if err == nil {
+			return filename, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", fmt.Errorf("error reading results file: %v", err)
+		}
+	}
+	return "", nil
+}
+
+// compressionSibling returns the gzipped name for an uncompressed results file, and the
+// uncompressed name for a gzipped one
+func compressionSibling(filename string) string {
+	if trimmed, ok := strings.CutSuffix(filename, ".gz"); ok {
+		return trimmed
+	}
+	return filename + ".gz"
+}
+
+// LoadResultsFile loads the results saved in filename, either a JSON array, gzipped when
+// the name ends in .gz, or NDJSON or CSV when the name ends in .ndjson or .csv. A missing
+// file is reported with an error satisfying os.IsNotExist.
+func LoadResultsFile(filename string) ([]PostcodeResult, error) {
+	switch {
+	case strings.HasSuffix(filename, ".ndjson"):
+		return loadNDJSONResults(filename)
+	case strings.HasSuffix(filename, ".csv"):
+		return loadCSVResults(filename)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error reading results file: %v", err)
+	}
+
+	if strings.HasSuffix(filename, ".gz") {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("error opening gzip stream: %v", err)
+		}
+		data, err = io.ReadAll(gz)
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing results file: %v", err)
+		}
+	}
+
+	var results []PostcodeResult
+	if err := json.Unmarshal(data, &results); err != nil {
+		return nil, fmt.Errorf("error parsing results file: %v", err)
+	}
+
+	return results, nil
+}
+
+// resultStream calls fn with each of a set of results in turn, stopping at and returning
+// the first error, so results can be saved without all being held in memory at once
+type resultStream func(fn func(PostcodeResult) error) error
+
+// streamSlice returns a stream of the results in a slice
+func streamSlice(results []PostcodeResult) resultStream {
+	return func(fn func(PostcodeResult) error) error {
+		for _, result := range results {
+			if err := fn(result); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// streamResultsFile returns a stream of the results saved in filename, in any of the formats
+// LoadResultsFile reads, decoding one result at a time. A postcode saved more than once in an
+// NDJSON file is streamed each time. A missing file is reported with an error satisfying
+// os.IsNotExist.
+func streamResultsFile(filename string) resultStream {
+	switch {
+	case strings.HasSuffix(filename, ".ndjson"):
+		return streamNDJSONResults(filename)
+	case strings.HasSuffix(filename, ".csv"):
+		return streamCSVResults(filename)
+	}
+
+	return func(fn func(PostcodeResult) error) error {
+		file, err := os.Open(filename)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return err
+			}
+			return fmt.Errorf("error reading results file: %v", err)
+		}
+		defer file.Close()
+
+		var r io.Reader = bufio.NewReader(file)
+		if strings.HasSuffix(filename, ".gz") {
+			gz, err := gzip.NewReader(r)
+			if err != nil {
+				return fmt.Errorf("error opening gzip stream: %v", err)
+			}
+			r = gz
+		}
+
+		decoder := json.NewDecoder(r)
+		if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+			return fmt.Errorf("error parsing results file: expected a JSON array")
+		}
+		for decoder.More() {
+			var result PostcodeResult
+			if err := decoder.Decode(&result); err != nil {
+				return fmt.Errorf("error parsing results file: %v", err)
+			}
+			if err := fn(result); err != nil {
+				return err
+			}
+		}
+		if _, err := decoder.Token(); err != nil {
+			return fmt.Errorf("error parsing results file: %v", err)
+		}
+		return nil
+	}
+}
+
+// saveResults writes the results in the requested output format(s), with JSON going to
+// output and the other formats to files named after it. Results are sorted by postcode so
+// files are stable across runs whatever order the workers finished in; the caller's slice
+// is left in its original order.
+func saveResults(results []PostcodeResult, format, output string) error {
+	results = slices.Clone(results)
+	slices.SortStableFunc(results, func(a, b PostcodeResult) int {
+		return strings.Compare(a.Postcode, b.Postcode)
+	})
+	return saveSortedResults(streamSlice(results), format, output)
+}
+
+// saveSortedResults writes a stream of results already sorted by postcode in the requested
+// output format(s), like saveResults, streaming it once for each file written
+func saveSortedResults(results resultStream, format, output string) error {
+	if format == "json" || format == "both" {
+		if err := saveResultsToJSON(results, output); err != nil {
+			return err
+		}
+	}
+	if format == "csv" || format == "both" {
+		if err := saveResultsToCSV(results, csvResultsFile(output)); err != nil {
+			return err
+		}
+	}
+	if format == "ndjson" {
+		if err := saveResultsToNDJSON(results, ndjsonResultsFile(output)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveResultsFile writes results to filename as CSV or NDJSON when the name ends in .csv or
+// .ndjson, and as a JSON array otherwise
+func SaveResultsFile(results []PostcodeResult, filename string) error {
+	switch {
+	case strings.HasSuffix(filename, ".csv"):
+		return saveResultsToCSV(streamSlice(results), filename)
+	case strings.HasSuffix(filename, ".ndjson"):
+		return saveResultsToNDJSON(streamSlice(results), filename)
+	default:
+		return saveResultsToJSON(streamSlice(results), filename)
+	}
+}
+
+// saveResultsToJSON saves a stream of results into a JSON file
+func saveResultsToJSON(results resultStream, filename string) error {
+	// Write JSON data to a file, compressed when the name ends in .gz
+	err := writeFileAtomic(filename, func(w io.Writer) error {
+		if !strings.HasSuffix(filename, ".gz") {
+			return writeResultsJSON(w, results)
+		}
+		gz := gzip.NewWriter(w)
+		if err := writeResultsJSON(gz, results); err != nil {
+			return err
+		}
+		return gz.Close()
+	})
+	if err != nil {
+		return fmt.Errorf("error writing to JSON file: %v", err)
+	}
+
+	slog.Debug("Results saved", "file", filename)
+	return nil
+}
+
+// writeResultsJSON writes a stream of results to w as an indented JSON array, formatted as
+// json.MarshalIndent would but encoding one result at a time as the stream yields it, so it
+// holds no more results in memory than the stream does
+func writeResultsJSON(w io.Writer, results resultStream) error {
+	buf := bufio.NewWriter(w)
+
+	// The encoder ends each result with a newline, which has to come after the comma
+	var element bytes.Buffer
+	encoder := json.NewEncoder(&element)
+	encoder.SetIndent("  ", "  ")
+
+	written := 0
+	err := results(func(result PostcodeResult) error {
+		element.Reset()
+		if err := encoder.Encode(result); err != nil {
+			return fmt.Errorf("error marshalling results to JSON: %v", err)
+		}
+		if written == 0 {
+			buf.WriteString("[\n")
+		} else {
+			buf.WriteString(",\n")
+		}
+		buf.WriteString("  ")
+		buf.Write(bytes.TrimSuffix(element.Bytes(), []byte("\n")))
+		written++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if written == 0 {
+		buf.WriteString("[]")
+	} else {
+		buf.WriteString("\n]")
+	}
+	return buf.Flush()
+}
+
+// csvHeader names the columns of CSV results files, in order
+var csvHeader = []string{"postcode", "supplier", "phone", "link", "sewerage_supplier", "sewerage_phone", "sewerage_link", "fetched_at", "email", "sewerage_email", "address", "sewerage_address", "link_title", "approximated_from"}
+
+// saveResultsToCSV saves a stream of results into a CSV file with a header row
+func saveResultsToCSV(results resultStream, filename string) error {
+	err := writeFileAtomic(filename, func(w io.Writer) error {
+		writer := csv.NewWriter(w)
+
+		// Write the header row followed by one row per result
+		if err := writer.Write(csvHeader); err != nil {
+			return fmt.Errorf("error writing CSV header: %v", err)
+		}
+		err := results(func(result PostcodeResult) error {
+			record := []string{
+				result.Postcode, result.Supplier, result.Phone, result.Link,
+				result.SewerageSupplier, result.SeweragePhone, result.SewerageLink,
+				formatFetchedAt(result.FetchedAt), result.Email, result.SewerageEmail,
+				result.Address, result.SewerageAddress, result.LinkTitle, result.ApproximatedFrom,
+			}
+			if err := writer.Write(record); err != nil {
+				return fmt.Errorf("error writing CSV row: %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		writer.Flush()
+		return writer.Error()
+	})
+	if err != nil {
+		return fmt.Errorf("error writing to CSV file: %v", err)
+	}
+
+	slog.Debug("Results saved", "file", filename)
+	return nil
+}
+
+// loadCSVResults reads the results in a CSV file written by saveResultsToCSV. A missing file
+// is reported with an error satisfying os.IsNotExist.
+func loadCSVResults(filename string) ([]PostcodeResult, error) {
+	results := []PostcodeResult{}
+	err := streamCSVResults(filename)(func(result PostcodeResult) error {
+		results = append(results, result)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// streamCSVResults returns a stream of the results in a CSV file written by saveResultsToCSV.
+// Columns are matched by the header row, so files from versions with fewer columns still
+// load. A missing file is reported with an error satisfying os.IsNotExist.
+func streamCSVResults(filename string) resultStream {
+	return func(fn func(PostcodeResult) error) error {
+		file, err := os.Open(filename)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return err
+			}
+			return fmt.Errorf("error opening CSV file: %v", err)
+		}
+		defer file.Close()
+
+		reader := csv.NewReader(bufio.NewReader(file))
+		header, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading CSV file: %v", err)
+		}
+		columns := make(map[string]int, len(header))
+		for i, name := range header {
+			columns[name] = i
+		}
+		if _, ok := columns["postcode"]; !ok {
+			return fmt.Errorf("error reading CSV file: no postcode column in %s", filename)
+		}
+
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("error reading CSV file: %v", err)
+			}
+			field := func(name string) string {
+				if i, ok := columns[name]; ok {
+					return record[i]
+				}
+				return ""
+			}
+
+			// Only definitive answers are saved, and a row without a supplier had no coverage
+			result := PostcodeResult{
+				Postcode:         field("postcode"),
+				Supplier:         field("supplier"),
+				Phone:            field("phone"),
+				Link:             field("link"),
+				Email:            field("email"),
+				Address:          field("address"),
+				SewerageSupplier: field("sewerage_supplier"),
+				SeweragePhone:    field("sewerage_phone"),
+				SewerageLink:     field("sewerage_link"),
+				SewerageEmail:    field("sewerage_email"),
+				SewerageAddress:  field("sewerage_address"),
+				LinkTitle:        field("link_title"),
+				ApproximatedFrom: field("approximated_from"),
+				Status:           StatusFound,
+			}
+			if result.Supplier == "" || result.Supplier == "Not Found" {
+				result.Status = StatusNotFound
+			}
+			if fetchedAt := field("fetched_at"); fetchedAt != "" {
+				result.FetchedAt, err = time.Parse(time.RFC3339, fetchedAt)
+				if err != nil {
+					return fmt.Errorf("error parsing fetched_at for postcode %s: %v", result.Postcode, err)
+				}
+			}
+			if err := fn(result); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// formatFetchedAt formats a result timestamp as RFC3339, or empty for results from runs
+// that predate timestamps
+func formatFetchedAt(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,167 @@
+package supplier
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testResults returns a found result with every saved field set and a not found result
+func testResults() []PostcodeResult {
+	fetchedAt := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	return []PostcodeResult{
+		{
+			Postcode: "SW1A 1AA", Supplier: "Affinity Water", Phone: "0345 357 2407", Link: "https://www.affinitywater.co.uk",
+			Email: "help@affinitywater.co.uk", Address: "Tamblin Way, Hatfield, AL10 9EZ",
+			SewerageSupplier: "Thames Water, \"Sewerage\"", SeweragePhone: "0800 316 9800", SewerageLink: "https://www.thameswater.co.uk",
+			SewerageEmail: "help@thameswater.co.uk", SewerageAddress: "Clearwater Court,\nReading",
+			LinkTitle: "Affinity Water", FetchedAt: fetchedAt, Status: StatusFound, ApproximatedFrom: "SW1A 2AA",
+		},
+		{Postcode: "ZE3 9JZ", Supplier: "Not Found", Phone: "Not Found", Link: "Not Found", FetchedAt: fetchedAt, Status: StatusNotFound},
+	}
+}
+
+func TestCSVResultsRoundTrip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "results.csv")
+	want := testResults()
+
+	if err := saveResultsToCSV(streamSlice(want), filename); err != nil {
+		t.Fatalf("saveResultsToCSV() error = %v", err)
+	}
+	got, err := LoadResultsFile(filename)
+	if err != nil {
+		t.Fatalf("LoadResultsFile() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadResultsFile() =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestLoadCSVResultsOlderColumns(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "results.csv")
+	data := "postcode,supplier,phone,link\n" +
+		"SW1A 1AA,Thames Water,0800 316 9800,https://www.thameswater.co.uk\n" +
+		"ZE3 9JZ,,,\n"
+	if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := loadCSVResults(filename)
+	if err != nil {
+		t.Fatalf("loadCSVResults() error = %v", err)
+	}
+	want := []PostcodeResult{
+		{Postcode: "SW1A 1AA", Supplier: "Thames Water", Phone: "0800 316 9800", Link: "https://www.thameswater.co.uk", Status: StatusFound},
+		{Postcode: "ZE3 9JZ", Status: StatusNotFound},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loadCSVResults() =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestLoadCSVResultsErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := loadCSVResults(filepath.Join(dir, "missing.csv")); !os.IsNotExist(err) {
+		t.Errorf("loadCSVResults(missing) error = %v, want not exist", err)
+	}
+
+	noPostcode := filepath.Join(dir, "no_postcode.csv")
+	if err := os.WriteFile(noPostcode, []byte("supplier,phone\nThames Water,0800 316 9800\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadCSVResults(noPostcode); err == nil {
+		t.Error("loadCSVResults(no postcode column) error = nil, want error")
+	}
+
+	badTime := filepath.Join(dir, "bad_time.csv")
+	if err := os.WriteFile(badTime, []byte("postcode,fetched_at\nSW1A 1AA,yesterday\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadCSVResults(badTime); err == nil {
+		t.Error("loadCSVResults(bad fetched_at) error = nil, want error")
+	}
+}
+
+func TestResultsFileRoundTrip(t *testing.T) {
+	for _, name := range []string{"results.json", "results.json.gz", "results.ndjson"} {
+		t.Run(name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), name)
+			want := testResults()
+
+			if err := SaveResultsFile(want, filename); err != nil {
+				t.Fatalf("SaveResultsFile() error = %v", err)
+			}
+			got, err := LoadResultsFile(filename)
+			if err != nil {
+				t.Fatalf("LoadResultsFile() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("LoadResultsFile() =\n%+v\nwant\n%+v", got, want)
+			}
+		})
+	}
+}
+
+func TestWriteResultsJSONMatchesMarshalIndent(t *testing.T) {
+	for _, results := range [][]PostcodeResult{{}, testResults()[:1], testResults()} {
+		want, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		filename := filepath.Join(t.TempDir(), "results.json")
+		if err := saveResultsToJSON(streamSlice(results), filename); err != nil {
+			t.Fatalf("saveResultsToJSON() error = %v", err)
+		}
+		got, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(want) {
+			t.Errorf("saveResultsToJSON(%d results) wrote\n%s\nwant\n%s", len(results), got, want)
+		}
+	}
+}
+
+func TestSaveResultsSortsAndNamesFiles(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "out.json")
+	results := testResults()
+	results[0], results[1] = results[1], results[0]
+
+	if err := saveResults(results, "both", output); err != nil {
+		t.Fatalf("saveResults() error = %v", err)
+	}
+	if results[0].Postcode != "ZE3 9JZ" {
+		t.Errorf("saveResults() reordered the caller's slice")
+	}
+
+	for _, filename := range []string{output, filepath.Join(dir, "out.csv")} {
+		got, err := LoadResultsFile(filename)
+		if err != nil {
+			t.Fatalf("LoadResultsFile(%s) error = %v", filename, err)
+		}
+		if len(got) != 2 || got[0].Postcode != "SW1A 1AA" || got[1].Postcode != "ZE3 9JZ" {
+			t.Errorf("%s holds %+v, want results sorted by postcode", filename, got)
+		}
+	}
+}
+
+func TestResultsFileAs(t *testing.T) {
+	tests := []struct {
+		output, ext, want string
+	}{
+		{"water_suppliers_results.json", ".csv", "water_suppliers_results.csv"},
+		{"out/results.json.gz", ".csv", "out/results.csv"},
+		{"results", ".ndjson", "results.ndjson"},
+	}
+
+	for _, tt := range tests {
+		if got := resultsFileAs(tt.output, tt.ext); got != tt.want {
+			t.Errorf("resultsFileAs(%q, %q) = %q, want %q", tt.output, tt.ext, got, tt.want)
+		}
+	}
+}
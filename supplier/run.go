@@ -0,0 +1,1135 @@
+package supplier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// DefaultConcurrency is the number of postcodes looked up at once when not set
+	DefaultConcurrency = 3
+
+	// Results and progress are saved after this many new results or this long since the
+	// last save, whichever comes first, bounding what a crash can lose
+	saveEvery    = 10
+	saveInterval = 30 * time.Second
+)
+
+// Options configures a Run
+type Options struct {
+	Fetcher     *Fetcher // Looks up each postcode, NewFetcher() if nil
+	Concurrency int      // Postcodes looked up at once, DefaultConcurrency if zero
+
+	// Adaptive starts at Concurrency and adjusts it to response latency and errors, up to
+	// MaxConcurrency (DefaultMaxConcurrency if zero)
+	Adaptive       bool
+	MaxConcurrency int
+
+	// ByOutwardCode looks up one postcode per outward code and copies its answer to the
+	// rest, an approximation that saves many requests where suppliers follow outward codes
+	ByOutwardCode bool
+
+	// Enrich follows the supplier link of each found result to add its page title, fetching
+	// at most EnrichConcurrency (DefaultEnrichConcurrency if zero) links at once. Each link is
+	// fetched once per run.
+	Enrich            bool
+	EnrichConcurrency int
+
+	// MinDelay and MaxDelay bound a random pause each worker takes before every lookup, to
+	// spread load and look less robotic; both zero for no pause
+	MinDelay time.Duration
+	MaxDelay time.Duration
+
+	Dir            string    // Directory of postcode CSV files (plain or .csv.gz)
+	File           string    // When set, only this postcode CSV file is processed instead of Dir
+	Input          io.Reader // When set, postcodes are read one per line from it instead of Dir
+	HasHeader      bool      // Always skip the first CSV row; otherwise only when it isn't a postcode
+	PostcodeColumn int       // Zero-based index of the CSV column holding the postcode
+	Delimiter      string    // CSV field delimiter: a single character, "tab", or "auto"; comma if empty
+
+	// StartFile and EndFile restrict processing to the inclusive range of Dir's sorted files
+	// between these base names, for sharding a corpus; empty for the first and last file
+	StartFile string
+	EndFile   string
+
+	Format string // Output format: json (the default), csv, both, or ndjson
+	Store  string // Result storage backend: json (the default) or sqlite
+
+	// Output is the JSON results file, ResultsFile if empty. The csv and ndjson formats are
+	// saved beside it, named after it with their own extension.
+	Output string
+
+	Database string // SQLite database used by the sqlite store, DatabaseFile if empty
+
+	// Files keeping the run's state between runs, each defaulting to the package constant of
+	// the same name when empty. The progress file is locked for the run, as are the results.
+	ProgressFile        string
+	FailedPostcodesFile string
+	DeadLetterFile      string
+	ManifestFile        string
+
+	DryRun           bool          // Only log the work that would be done, without any requests
+	Limit            int           // Stop after attempting this many postcodes, zero for no limit
+	RetryFailed      bool          // Only re-attempt the postcodes recorded in FailedPostcodesFile
+	ResetDeadLetter  bool          // Clear DeadLetterFile so its postcodes are attempted again
+	RefetchOlderThan time.Duration // Look up stored results older than this again, zero to never
+	SkipFile         string        // Newline-delimited postcodes never to look up, empty for none
+	OnlyFile         string        // Newline-delimited postcodes to look up, ignoring all others; empty for all
+	Force            bool          // Walk every file again, even those completed and unchanged
+
+	// NoResume starts a clean run, ignoring earlier progress and failures and replacing the
+	// earlier results rather than adding to them
+	NoResume bool
+
+	// ProcessedIndex is an on-disk index of processed postcodes used instead of loading every
+	// earlier result at startup, for corpora too big to hold in memory; empty to disable
+	ProcessedIndex string
+
+	// OnResult, when set, is called with every lookup result as it completes, errors
+	// included, so embedding code can react to each one without waiting for the results
+	// file. Calls are made one at a time from a single goroutine; a slow callback slows
+	// the run.
+	OnResult func(PostcodeResult)
+
+	// Metrics counts the run's lookups, and the Fetcher's requests unless it has its own;
+	// nil to not count them
+	Metrics *Metrics
+
+	// MaxConsecutiveFailures stops the run once this many postcodes in a row fail, as that
+	// usually means a broken token or a site change; zero to never stop
+	MaxConsecutiveFailures int
+}
+
+// validate fills in defaults and checks the options make sense
+func (o *Options) validate() error {
+	if o.Fetcher == nil {
+		o.Fetcher = NewFetcher()
+	}
+	if o.Fetcher.Metrics == nil {
+		o.Fetcher.Metrics = o.Metrics
+	}
+	if o.Concurrency == 0 {
+		o.Concurrency = DefaultConcurrency
+	}
+	if o.Format == "" {
+		o.Format = "json"
+	}
+	if o.Store == "" {
+		o.Store = "json"
+	}
+	if o.Output == "" {
+		o.Output = ResultsFile
+	}
+	if o.Database == "" {
+		o.Database = DatabaseFile
+	}
+	if o.ProgressFile == "" {
+		o.ProgressFile = ProgressFile
+	}
+	if o.FailedPostcodesFile == "" {
+		o.FailedPostcodesFile = FailedPostcodesFile
+	}
+	if o.DeadLetterFile == "" {
+		o.DeadLetterFile = DeadLetterFile
+	}
+	if o.ManifestFile == "" {
+		o.ManifestFile = ManifestFile
+	}
+	if o.Enrich && o.EnrichConcurrency == 0 {
+		o.EnrichConcurrency = DefaultEnrichConcurrency
+	}
+	if o.Adaptive && o.MaxConcurrency == 0 {
+		o.MaxConcurrency = max(DefaultMaxConcurrency, o.Concurrency)
+	}
+
+	switch {
+	case o.Concurrency < 1:
+		return fmt.Errorf("invalid concurrency %d: must be at least 1", o.Concurrency)
+	case o.Adaptive && o.MaxConcurrency < o.Concurrency:
+		return fmt.Errorf("invalid max concurrency %d: must be at least the concurrency %d", o.MaxConcurrency, o.Concurrency)
+	case o.EnrichConcurrency < 0:
+		return fmt.Errorf("invalid enrich concurrency %d: must not be negative", o.EnrichConcurrency)
+	case o.MinDelay < 0 || o.MaxDelay < o.MinDelay:
+		return fmt.Errorf("invalid delay range %s to %s: must not be negative or reversed", o.MinDelay, o.MaxDelay)
+	case o.File != "" && o.Input != nil:
+		return fmt.Errorf("a postcode file can't be used with an input reader")
+	case o.PostcodeColumn < 0:
+		return fmt.Errorf("invalid postcode column %d: must not be negative", o.PostcodeColumn)
+	case o.Limit < 0:
+		return fmt.Errorf("invalid limit %d: must not be negative", o.Limit)
+	case o.RefetchOlderThan < 0:
+		return fmt.Errorf("invalid refetch age %s: must not be negative", o.RefetchOlderThan)
+	case o.MaxConsecutiveFailures < 0:
+		return fmt.Errorf("invalid max consecutive failures %d: must not be negative", o.MaxConsecutiveFailures)
+	}
+
+	if _, err := parseDelimiter(o.Delimiter); err != nil {
+		return err
+	}
+
+	switch o.Format {
+	case "json", "csv", "both", "ndjson":
+	default:
+		return fmt.Errorf("invalid format %q: must be json, csv, both, or ndjson", o.Format)
+	}
+
+	switch o.Store {
+	case "json", "sqlite":
+	default:
+		return fmt.Errorf("invalid store %q: must be json or sqlite", o.Store)
+	}
+
+	return nil
+}
+
+// Run looks up every postcode in the input, saving results, failures, and progress as it
+// goes so an interrupted run resumes where it left off. Cancelling ctx stops the run
+// cleanly with everything collected so far saved. The returned summary describes what the
+// run did, even when it ends with an error.
+func Run(ctx context.Context, opts Options) (*RunSummary, error) {
+	summary := &RunSummary{StartedAt: time.Now()}
+	if err := opts.validate(); err != nil {
+		return summary, err
+	}
+	defer func() { summary.finish(opts.Fetcher.Requests()) }()
+
+	// Create the directories of the results and state files up front so the first save
+	// doesn't fail
+	if !opts.DryRun {
+		for _, file := range []string{opts.Output, opts.Database, opts.ProgressFile, opts.FailedPostcodesFile, opts.DeadLetterFile, opts.ManifestFile} {
+			if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+				return summary, fmt.Errorf("error creating output directory: %v", err)
+			}
+		}
+	}
+
+	// Only one run at a time may write the progress and results files, so both are locked:
+	// two runs sharing either would clobber each other's saves
+	if !opts.DryRun {
+		locked := []string{opts.ProgressFile, opts.Output}
+		if opts.Store == "sqlite" {
+			locked = append(locked, opts.Database)
+		}
+		for _, file := range locked {
+			lock, err := lockFile(lockFilename(file))
+			if err != nil {
+				return summary, err
+			}
+			defer func() {
+				if err := unlockFile(lock); err != nil {
+					slog.Warn("Error releasing lock", "err", err)
+				}
+			}()
+		}
+	}
+
+	// Load progress from previous run
+	progress, err := loadProgress(opts.ProgressFile)
+	if err != nil {
+		return summary, err
+	}
+	if opts.NoResume {
+		progress = &Progress{}
+	}
+
+	// Create a set of processed postcodes for quick lookup, treating results older than
+	// RefetchOlderThan as unprocessed
+	var staleBefore time.Time
+	if opts.RefetchOlderThan > 0 {
+		staleBefore = summary.StartedAt.Add(-opts.RefetchOlderThan)
+	}
+	processedPostcodes := newPostcodeSet(staleBefore)
+	defer processedPostcodes.Close()
+
+	// With a processed index, postcodes from earlier runs are looked up on disk instead of
+	// loading every result, provided the index is newer than the results it covers
+	indexed := opts.ProcessedIndex != "" && !opts.NoResume && indexFresh(opts.ProcessedIndex, resultSources(opts)...)
+	if indexed {
+		if err := processedPostcodes.useIndex(opts.ProcessedIndex); err != nil {
+			return summary, err
+		}
+		slog.Info("Using processed index", "file", opts.ProcessedIndex)
+	}
+
+	// Load any existing results, either from the database or the results file
+	var store *ResultStore
+	var stream *ndjsonWriter
+	switch opts.Store {
+	case "sqlite":
+		store, err = Open(opts.Database)
+		if err != nil {
+			return summary, err
+		}
+		defer store.Close()
+		if opts.NoResume {
+			if opts.DryRun {
+				slog.Warn("Clean run would clear earlier results", "file", opts.Database)
+				break
+			}
+			if err := store.Clear(); err != nil {
+				return summary, err
+			}
+			slog.Warn("Cleared earlier results for a clean run", "file", opts.Database)
+			break
+		}
+		if indexed {
+			break
+		}
+
+		storedPostcodes, err := store.FetchTimes()
+		if err != nil {
+			return summary, err
+		}
+		for postcode, fetchedAt := range storedPostcodes {
+			processedPostcodes.Add(postcode, fetchedAt)
+		}
+	case "json":
+		// Results are streamed to disk as they complete rather than held in memory. The
+		// ndjson format appends to its results file directly; the others append to a journal
+		// that is folded into the results file whenever the run finishes.
+		streamFile := ndjsonResultsFile(opts.Output)
+		if opts.NoResume {
+			// Drop the earlier results so nothing of them is folded into this run's
+			if err := removeEarlierResults(opts); err != nil {
+				return summary, err
+			}
+			if opts.Format != "ndjson" {
+				streamFile = journalFile(opts.Output)
+			}
+		} else if opts.Format != "ndjson" {
+			streamFile = journalFile(opts.Output)
+
+			// Fold in anything journaled by an earlier run that never got to save
+			if !opts.DryRun {
+				if err := compactJournal(opts.Format, opts.Output); err != nil {
+					return summary, err
+				}
+			}
+
+			if !indexed {
+				existingResults, err := loadExistingResults(opts.Format, opts.Output)
+				if err != nil {
+					return summary, err
+				}
+				for _, result := range existingResults {
+					processedPostcodes.Add(result.Postcode, result.FetchedAt)
+				}
+			}
+		}
+
+		if !indexed && !opts.NoResume {
+			streamedPostcodes, err := loadNDJSONFetchTimes(streamFile)
+			if err != nil {
+				return summary, err
+			}
+			for postcode, fetchedAt := range streamedPostcodes {
+				processedPostcodes.Add(postcode, fetchedAt)
+			}
+		}
+
+		if !opts.DryRun {
+			stream, err = openNDJSON(streamFile)
+			if err != nil {
+				return summary, err
+			}
+			defer stream.Close()
+		}
+	}
+
+	// Build the index from the results just loaded so the next run can skip loading them
+	if opts.ProcessedIndex != "" && !indexed && !opts.DryRun {
+		if err := processedPostcodes.saveIndex(opts.ProcessedIndex); err != nil {
+			return summary, err
+		}
+		slog.Info("Built processed index", "file", opts.ProcessedIndex)
+	}
+
+	// Postcodes the user never wants requested, whatever earlier runs did
+	skipList := map[string]bool{}
+	if opts.SkipFile != "" {
+		skipList, err = loadPostcodeList(opts.SkipFile)
+		if err != nil {
+			return summary, err
+		}
+		slog.Info("Loaded skip list", "file", opts.SkipFile, "postcodes", len(skipList))
+	}
+
+	// With an allowlist, the only postcodes the user wants requested
+	var onlyList map[string]bool
+	if opts.OnlyFile != "" {
+		onlyList, err = loadPostcodeList(opts.OnlyFile)
+		if err != nil {
+			return summary, err
+		}
+		slog.Info("Loaded allowlist", "file", opts.OnlyFile, "postcodes", len(onlyList))
+	}
+
+	// Postcodes that failed even in a retry pass are left alone until the dead letter is reset,
+	// when they go back on the failed list for the next retry pass
+	deadLetter, err := loadFailedPostcodes(opts.DeadLetterFile)
+	if err != nil {
+		return summary, err
+	}
+	if opts.ResetDeadLetter && len(deadLetter) > 0 {
+		slog.Info("Resetting dead letter", "postcodes", len(deadLetter))
+	}
+	deadLettered := make(map[string]bool, len(deadLetter))
+	for postcode := range deadLetter {
+		if !opts.ResetDeadLetter {
+			deadLettered[postcode] = true
+		}
+	}
+
+	// wanted reports whether the lists leave postcode to be looked up
+	wanted := func(postcode string) bool {
+		return !skipList[postcode] && !deadLettered[postcode] && (onlyList == nil || onlyList[postcode])
+	}
+
+	// With an Input reader postcodes are read one per line from it instead of CSV files
+	var files, inputPostcodes []string
+	if opts.Input != nil {
+		var invalid int
+		inputPostcodes, invalid, err = readPostcodeLines(opts.Input, "stdin")
+		if err != nil {
+			return summary, err
+		}
+		if invalid > 0 {
+			slog.Warn("Skipped invalid postcodes", "file", "stdin", "count", invalid)
+		}
+	} else if opts.File != "" {
+		if _, err := os.Stat(opts.File); err != nil {
+			return summary, fmt.Errorf("error reading postcode file: %v", err)
+		}
+		files = []string{opts.File}
+	} else {
+		files, err = postcodeFiles(opts.Dir)
+		if err != nil {
+			return summary, err
+		}
+	}
+
+	comma, _ := parseDelimiter(opts.Delimiter) // Checked by validate
+	readOpts := csvOptions{HasHeader: opts.HasHeader, Column: opts.PostcodeColumn, Comma: comma}
+
+	// Files before the last one recorded by an older progress file were completed
+	progress.migrate(files)
+
+	if opts.Input == nil && (opts.StartFile != "" || opts.EndFile != "") {
+		files, err = fileRange(files, opts.StartFile, opts.EndFile)
+		if err != nil {
+			return summary, err
+		}
+		slog.Info("Selected file range", "start", filepath.Base(files[0]), "end", filepath.Base(files[len(files)-1]), "files", len(files))
+	}
+
+	// Files whose content changed since the manifest recorded them are processed again, and
+	// unchanged files it records as completed are skipped whole, unless everything is forced
+	manifest, err := loadManifest(opts.ManifestFile)
+	if err != nil {
+		return summary, err
+	}
+	if (opts.Force || opts.NoResume) && len(files) > 0 {
+		slog.Info("Forcing every file to be processed again", "files", len(files))
+	}
+	checksums := make(map[string]string, len(files))
+	unchanged := 0
+	for _, file := range files {
+		filename := filepath.Base(file)
+		checksums[filename], err = fileChecksum(file)
+		if err != nil {
+			return summary, err
+		}
+
+		entry, ok := manifest[filename]
+		switch {
+		case opts.Force || opts.NoResume:
+			progress.resetFile(filename)
+			delete(manifest, filename)
+		case ok && entry.SHA256 != checksums[filename]:
+			slog.Info("File changed since last run, processing it again", "file", filename)
+			progress.resetFile(filename)
+			delete(manifest, filename)
+		case ok:
+			progress.markCompleted(filename)
+			unchanged++
+		case progress.fileCompleted(filename):
+			// Completed before the manifest existed, so only its checksum is known
+			manifest[filename] = ManifestEntry{File: filename, SHA256: checksums[filename]}
+		}
+	}
+	if unchanged > 0 {
+		slog.Info("Skipping unchanged files", "count", unchanged)
+	}
+
+	// In dry-run mode just report the work remaining after resume and dedup
+	if opts.DryRun {
+		plannedFiles, plannedPostcodes := 0, 0
+		if opts.Input != nil {
+			for _, postcode := range inputPostcodes {
+				if wanted(postcode) && !processedPostcodes.Has(postcode) {
+					plannedPostcodes++
+				}
+			}
+			slog.Info("Planned", "file", "stdin", "postcodes", len(inputPostcodes), "pending", plannedPostcodes)
+			if plannedPostcodes > 0 {
+				plannedFiles++
+			}
+		}
+		for _, file := range files {
+			filename := filepath.Base(file)
+			if progress.fileCompleted(filename) {
+				continue
+			}
+			postcodes, stats, err := getPostcodesFromCSV(file, readOpts)
+			if err != nil {
+				slog.Error("Error reading CSV file", "file", file, "err", err)
+				continue
+			}
+
+			start, done := resumePoint(progress, filename, postcodes)
+			pending := 0
+			for j, postcode := range postcodes[start:] {
+				if !done[start+j] && wanted(postcode) && !processedPostcodes.Has(postcode) {
+					pending++
+				}
+			}
+
+			args := []any{"file", filename, "postcodes", len(postcodes), "pending", pending, "invalid", stats.Invalid}
+			if start > 0 && start < len(postcodes) {
+				args = append(args, "resume_from", postcodes[start])
+			}
+			slog.Info("Planned", args...)
+
+			if pending > 0 {
+				plannedFiles++
+				plannedPostcodes += pending
+			}
+		}
+
+		slog.Info("Dry run complete", "files", plannedFiles, "postcodes", plannedPostcodes)
+		return summary, nil
+	}
+
+	// Load postcodes that failed in earlier runs so successes can clear them
+	failed, err := loadFailedPostcodes(opts.FailedPostcodesFile)
+	if err != nil {
+		return summary, err
+	}
+	if opts.NoResume {
+		clear(failed)
+	}
+	if opts.ResetDeadLetter {
+		for postcode, entry := range deadLetter {
+			failed[postcode] = entry
+		}
+		clear(deadLetter)
+	}
+
+	// Establish the site session before any lookups, which fetch the token themselves on failure
+	if err := opts.Fetcher.WarmUp(ctx); err != nil {
+		slog.Warn("Error warming up session, lookups will retry", "err", err)
+	}
+
+	// A failure to save results stops the run rather than carrying on and losing them
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var saveMu sync.Mutex
+	var saveErr error
+	saveFailed := func(err error) {
+		slog.Error("Error saving results, stopping", "err", err)
+		saveMu.Lock()
+		defer saveMu.Unlock()
+		if saveErr == nil {
+			saveErr = err
+			cancel()
+		}
+	}
+
+	// Results are written by a goroutine of their own, in batches, so lookups never wait on disk
+	var writer *resultWriter
+	if !opts.DryRun {
+		writer = startResultWriter(func(batch []PostcodeResult) error {
+			if store != nil {
+				return store.Insert(batch...)
+			}
+			for _, result := range batch {
+				if err := stream.Write(result); err != nil {
+					return err
+				}
+			}
+			return stream.Flush()
+		}, saveFailed)
+		defer writer.Close()
+	}
+
+	// Too many failures in a row stops the run too, keeping the last few errors to explain why
+	const recentErrorCount = 5
+	var abortErr error
+	var consecutiveFailures int
+	var recentErrors []string
+	recordFailure := func(result PostcodeResult) {
+		consecutiveFailures++
+		recentErrors = append(recentErrors, result.Postcode+": "+result.Error)
+		if len(recentErrors) > recentErrorCount {
+			recentErrors = recentErrors[1:]
+		}
+
+		if opts.MaxConsecutiveFailures == 0 || consecutiveFailures < opts.MaxConsecutiveFailures || abortErr != nil {
+			return
+		}
+		slog.Error("Too many consecutive failures, stopping", "failures", consecutiveFailures)
+		for _, msg := range recentErrors {
+			slog.Error("Recent failure", "err", msg)
+		}
+		abortErr = fmt.Errorf("stopped after %d consecutive failed postcodes", consecutiveFailures)
+		cancel()
+	}
+
+	// attempted counts postcodes queued for lookup, checked against Limit. Only the
+	// goroutine queueing work touches it until the lookups have finished.
+	attempted := 0
+	limitReached := func() bool { return opts.Limit > 0 && attempted >= opts.Limit }
+
+	// stopped reports whether to stop queueing new work
+	stopped := func() bool { return ctx.Err() != nil || limitReached() }
+
+	// collectResult records a single result, calling done once it is saved: straight away for
+	// failures, and once written to disk for answers, so progress never moves past a result
+	// that a crash could still lose
+	collectResult := func(result PostcodeResult, done func()) {
+		summary.record(result)
+		opts.Metrics.collected(result.Status)
+		if opts.OnResult != nil {
+			opts.OnResult(result)
+		}
+
+		// Errors are recorded for a later retry pass, or dead-lettered if this is the retry
+		// pass; found and no-coverage answers are kept
+		if !result.Status.Definitive() {
+			entry := FailedPostcode{
+				Postcode:   result.Postcode,
+				Error:      result.Error,
+				HTTPStatus: result.HTTPStatus,
+				Attempts:   result.Attempts,
+			}
+			if opts.RetryFailed {
+				if _, ok := deadLetter[result.Postcode]; !ok {
+					summary.DeadLettered++
+				}
+				deadLetter[result.Postcode] = entry
+				delete(failed, result.Postcode)
+			} else {
+				failed[result.Postcode] = entry
+			}
+			recordFailure(result)
+			done()
+			return
+		}
+
+		consecutiveFailures = 0
+		delete(failed, result.Postcode)
+		processedPostcodes.Add(result.Postcode, result.FetchedAt)
+		writer.Write(result, done)
+	}
+
+	// Postcodes that failed in earlier runs are retried before any files, so a normal run
+	// doesn't look them up again when it reaches them in their files
+	retriedFirst := map[string]bool{}
+
+	// deadLetterSkips counts dead-lettered postcodes skipped while queueing, which only the
+	// goroutine queueing work touches until runLookups adds it to the summary
+	deadLetterSkips := 0
+
+	// queuePostcodes sends postcodes[start:] from filename to jobs, leaving out those in done
+	// and skipping any already processed. Skipped postcodes are completed together with
+	// skipped, which may be nil, before each postcode is queued and once the rest are
+	// skipped. It returns false once no more work should be queued or ctx is cancelled.
+	queuePostcodes := func(ctx context.Context, jobs chan<- lookupJob, filename string, postcodes []string, start int, done map[int]bool, skipped func(filename string, indexes []int)) bool {
+		var skips []int
+		completeSkips := func() {
+			if len(skips) > 0 && skipped != nil {
+				skipped(filename, skips)
+			}
+			skips = skips[:0]
+		}
+		defer completeSkips()
+
+		for j := start; j < len(postcodes); j++ {
+			if stopped() {
+				return false
+			}
+			if done[j] {
+				continue
+			}
+
+			job := lookupJob{file: filename, index: j, postcode: postcodes[j]}
+			if deadLettered[job.postcode] {
+				slog.Debug("Skipping dead-lettered postcode", "postcode", job.postcode)
+				deadLetterSkips++
+				skips = append(skips, j)
+				continue
+			}
+			if job.file != "" && retriedFirst[job.postcode] {
+				slog.Debug("Skipping postcode retried earlier in this run", "postcode", job.postcode)
+				summary.Skipped++ // Never touched by the collector, so safe to update here
+				skips = append(skips, j)
+				continue
+			}
+			if skipList[job.postcode] {
+				slog.Debug("Skipping postcode in skip list", "postcode", job.postcode)
+				summary.SkipListed++ // Never touched by the collector, so safe to update here
+				skips = append(skips, j)
+				continue
+			}
+			// Left incomplete, so a later run without the allowlist still looks it up
+			if onlyList != nil && !onlyList[job.postcode] {
+				slog.Debug("Skipping postcode not in allowlist", "postcode", job.postcode)
+				summary.NotListed++ // Never touched by the collector, so safe to update here
+				continue
+			}
+			if processedPostcodes.Has(job.postcode) {
+				slog.Debug("Skipping already processed postcode", "postcode", job.postcode)
+				summary.Skipped++ // Never touched by the collector, so safe to update here
+				skips = append(skips, j)
+				continue
+			}
+
+			completeSkips()
+			attempted++
+			select {
+			case jobs <- job:
+			case <-ctx.Done():
+				return false
+			}
+		}
+		return true
+	}
+
+	var outward *outwardResolver
+	if opts.ByOutwardCode {
+		outward = newOutwardResolver()
+	}
+
+	var enricher *linkEnricher
+	if opts.Enrich {
+		enricher = newLinkEnricher(opts.Fetcher, opts.EnrichConcurrency)
+	}
+
+	// In adaptive mode there is a worker for the most lookups allowed at once, with the
+	// limiter deciding how many may run
+	workers := opts.Concurrency
+	var limiter *adaptiveLimiter
+	if opts.Adaptive {
+		workers = opts.MaxConcurrency
+		limiter = newAdaptiveLimiter(opts.Concurrency, opts.MaxConcurrency)
+	}
+
+	// runLookups looks up every job sent by produce using a fixed pool of workers shared
+	// across files, so concurrency stays saturated across file boundaries. The producer and
+	// workers run in an errgroup; results are collected on the calling goroutine, and
+	// complete is called for each finished job once its result is saved.
+	runLookups := func(produce func(ctx context.Context, jobs chan<- lookupJob), complete func(job lookupJob)) {
+		type lookup struct {
+			job    lookupJob
+			result PostcodeResult
+		}
+
+		jobs := make(chan lookupJob)
+		lookups := make(chan lookup, workers)
+		g, gctx := errgroup.WithContext(ctx)
+
+		// Producer: queues postcodes until done, stopped, or cancelled
+		g.Go(func() error {
+			defer close(jobs)
+			produce(gctx, jobs)
+			return nil
+		})
+
+		// Workers: look up queued postcodes until the queue is closed
+		for w := 0; w < workers; w++ {
+			g.Go(func() error {
+				for job := range jobs {
+					// A job never started because of cancellation is left for the next run
+					if opts.MaxDelay > 0 {
+						if err := sleepContext(gctx, politeDelay(opts.MinDelay, opts.MaxDelay)); err != nil {
+							continue
+						}
+					}
+					if limiter != nil {
+						if err := limiter.acquire(gctx); err != nil {
+							continue
+						}
+					}
+
+					start := time.Now()
+					var result PostcodeResult
+					if outward != nil {
+						result = outward.lookup(gctx, job.postcode, opts.Fetcher.getSupplierForPostcodeWithRetries)
+					} else {
+						result = opts.Fetcher.getSupplierForPostcodeWithRetries(gctx, job.postcode)
+					}
+					if limiter != nil {
+						limiter.release(time.Since(start), result.Status.Definitive() || gctx.Err() != nil)
+					}
+					if enricher != nil {
+						result = enricher.enrich(gctx, result)
+					}
+
+					// A lookup cut short by cancellation is left for the next run rather than
+					// recorded as a failure, so progress never moves past it
+					if gctx.Err() != nil && !result.Status.Definitive() {
+						continue
+					}
+
+					opts.Metrics.lookupDone(result.Status)
+
+					// Always deliver the result: the collector drains until every worker returns
+					lookups <- lookup{job: job, result: result}
+				}
+				return nil
+			})
+		}
+
+		// Close the results once the producer and every worker have returned
+		go func() {
+			if err := g.Wait(); err != nil && ctx.Err() == nil {
+				slog.Error("Lookup workers failed", "err", err)
+			}
+			close(lookups)
+		}()
+
+		for l := range lookups {
+			collectResult(l.result, func() { complete(l.job) })
+		}
+		summary.DeadLettered += deadLetterSkips
+		deadLetterSkips = 0
+
+		if summary.SkipListed > 0 {
+			slog.Info("Skipped postcodes in skip list", "count", summary.SkipListed)
+		}
+		if summary.NotListed > 0 {
+			slog.Info("Skipped postcodes not in allowlist", "count", summary.NotListed)
+		}
+	}
+
+	// saveAll writes out the results (exporting the database contents when using it, or
+	// folding in the journal) and the failed postcodes, returning the first error hit while
+	// saving during the run
+	saveAll := func() error {
+		// Failures are reported through saveFailed, and returned below
+		writer.Flush()
+
+		switch {
+		case store != nil:
+			storedResults, err := store.AllResults()
+			if err != nil {
+				return err
+			}
+			if err := saveResults(storedResults, opts.Format, opts.Output); err != nil {
+				return err
+			}
+		case opts.Format != "ndjson":
+			if err := compactJournal(opts.Format, opts.Output); err != nil {
+				return err
+			}
+		}
+
+		if err := saveFailedPostcodes(failed, opts.FailedPostcodesFile); err != nil {
+			return err
+		}
+		if err := saveFailedPostcodes(deadLetter, opts.DeadLetterFile); err != nil {
+			return err
+		}
+
+		// Written after the results so it counts as fresh against them next run
+		if opts.ProcessedIndex != "" {
+			if err := processedPostcodes.saveIndex(opts.ProcessedIndex); err != nil {
+				return err
+			}
+		}
+		saveMu.Lock()
+		defer saveMu.Unlock()
+		return saveErr
+	}
+
+	// In retry mode only the previously failed postcodes are looked up, leaving progress alone
+	if opts.RetryFailed {
+		var postcodes []string
+		for postcode := range failed {
+			if processedPostcodes.Has(postcode) {
+				delete(failed, postcode)
+				continue
+			}
+			postcodes = append(postcodes, postcode)
+		}
+		sort.Strings(postcodes)
+
+		slog.Info("Retrying failed postcodes", "count", len(postcodes))
+		alreadyDead := len(deadLetter)
+		ignore := func(lookupJob) {}
+		runLookups(func(ctx context.Context, jobs chan<- lookupJob) {
+			queuePostcodes(ctx, jobs, "", postcodes, 0, nil, nil)
+		}, ignore)
+		if err := saveAll(); err != nil {
+			return summary, err
+		}
+		if abortErr != nil {
+			return summary, abortErr
+		}
+
+		dead := len(deadLetter) - alreadyDead
+		slog.Info("Retry pass completed", "recovered", len(postcodes)-len(failed)-dead, "dead_lettered", dead, "not_attempted", len(failed))
+		return summary, nil
+	}
+
+	// Postcodes from Input are ad hoc lookups, so progress is neither resumed nor saved
+	if opts.Input != nil {
+		slog.Info("Processing postcodes from stdin", "count", len(inputPostcodes))
+		ignore := func(lookupJob) {}
+		runLookups(func(ctx context.Context, jobs chan<- lookupJob) {
+			queuePostcodes(ctx, jobs, "stdin", inputPostcodes, 0, nil, nil)
+		}, ignore)
+		if err := saveAll(); err != nil {
+			return summary, err
+		}
+		if abortErr != nil {
+			return summary, abortErr
+		}
+
+		slog.Info("Finished postcodes from stdin", "processed", summary.Processed, "skipped", summary.Skipped)
+		return summary, nil
+	}
+
+	// Resume positions are read from a copy, as the tracker updates progress while files
+	// are still being queued
+	resumeFrom := progress.clone()
+	tracker := newProgressTracker(progress, opts.ProgressFile)
+	tracker.onFileComplete = func(filename string, postcodes int) {
+		manifest[filename] = ManifestEntry{
+			File:        filename,
+			SHA256:      checksums[filename],
+			Rows:        postcodes,
+			CompletedAt: time.Now(),
+		}
+		if err := saveManifest(manifest, opts.ManifestFile); err != nil {
+			slog.Error("Error saving manifest", "file", filename, "err", err)
+		}
+	}
+	if err := saveManifest(manifest, opts.ManifestFile); err != nil {
+		return summary, err
+	}
+	complete := func(job lookupJob) {
+		if job.file == "" {
+			return // A failed postcode retried first, which has no place in any file
+		}
+		if err := tracker.complete(job.file, job.index); err != nil {
+			slog.Error("Error saving progress", "postcode", job.postcode, "err", err)
+		}
+	}
+	completeSkipped := func(filename string, indexes []int) {
+		if filename == "" {
+			return // Failed postcodes retried first have no place in any file
+		}
+		if err := tracker.complete(filename, indexes...); err != nil {
+			slog.Error("Error saving progress", "file", filename, "err", err)
+		}
+	}
+
+	// Retry earlier failures first, while whatever made them fail may have cleared; successes
+	// move into the results and out of the failed list as usual
+	var retryFirst []string
+	for postcode := range failed {
+		if wanted(postcode) && !processedPostcodes.Has(postcode) {
+			retryFirst = append(retryFirst, postcode)
+			retriedFirst[postcode] = true
+		}
+	}
+	sort.Strings(retryFirst)
+	if len(retryFirst) > 0 {
+		slog.Info("Retrying failed postcodes first", "count", len(retryFirst))
+	}
+
+	runLookups(func(ctx context.Context, jobs chan<- lookupJob) {
+		if !queuePostcodes(ctx, jobs, "", retryFirst, 0, nil, completeSkipped) {
+			return
+		}
+		for _, file := range files {
+			filename := filepath.Base(file)
+			if resumeFrom.fileCompleted(filename) {
+				slog.Debug("Skipping completed file", "file", filename)
+				continue
+			}
+			postcodes, stats, err := getPostcodesFromCSV(file, readOpts)
+			if err != nil {
+				slog.Error("Error reading CSV file", "file", file, "err", err)
+				continue
+			}
+			if stats.Headers > 0 {
+				slog.Info("Skipped header row", "file", filename, "count", stats.Headers)
+			}
+			if stats.Invalid > 0 {
+				slog.Warn("Skipped invalid postcodes", "file", filename, "count", stats.Invalid)
+			}
+
+			// Find starting postcode in current file
+			start, done := resumePoint(resumeFrom, filename, postcodes)
+			if start > 0 && start < len(postcodes) {
+				slog.Info("Resuming", "postcode", postcodes[start], "after", postcodes[start-1], "completed_after", len(done))
+			}
+
+			slog.Info("Processing file", "file", filename)
+			if err := tracker.addFile(filename, postcodes, start, done); err != nil {
+				slog.Error("Error saving progress", "file", filename, "err", err)
+			}
+			if !queuePostcodes(ctx, jobs, filename, postcodes, start, done, completeSkipped) {
+				return
+			}
+		}
+	}, complete)
+
+	// Save whatever was collected, exporting the full database contents when using it
+	if err := saveAll(); err != nil {
+		return summary, err
+	}
+	// Bring progress up to date with the postcodes completed since the tracker last saved
+	if err := tracker.flush(); err != nil {
+		return summary, err
+	}
+
+	if stopped() {
+		if err := saveProgress(progress, opts.ProgressFile); err != nil {
+			return summary, err
+		}
+		switch {
+		case abortErr != nil:
+			return summary, abortErr
+		case limitReached():
+			slog.Info("Postcode limit reached, progress saved", "limit", opts.Limit)
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			slog.Info("Deadline reached, progress saved",
+				"processed", summary.Processed, "files_completed", len(progress.CompletedFiles))
+		default:
+			slog.Info("Processing interrupted, progress saved")
+		}
+		return summary, nil
+	}
+
+	// Mark as completed, unless an allowlist left postcodes in some files for a later run
+	progress.Completed = len(progress.Files) == 0
+	if err := saveProgress(progress, opts.ProgressFile); err != nil {
+		return summary, fmt.Errorf("error saving final progress: %v", err)
+	}
+
+	slog.Info("Processing completed successfully")
+	return summary, nil
+}
+
+// politeDelay picks a random pause between shortest and longest inclusive
+func politeDelay(shortest, longest time.Duration) time.Duration {
+	return shortest + rand.N(longest-shortest+1)
+}
+
+// resultSources returns the files the results of earlier runs are read from, which the
+// processed index must be newer than to still cover them
+func resultSources(opts Options) []string {
+	switch {
+	case opts.Store == "sqlite":
+		return []string{opts.Database}
+	case opts.Format == "ndjson":
+		return []string{ndjsonResultsFile(opts.Output)}
+	default:
+		return []string{opts.Output, journalFile(opts.Output)}
+	}
+}
+
+// removeEarlierResults deletes the results files of the selected output and format for a
+// clean run, warning with each one removed; a dry run only warns. The output's compressed or
+// uncompressed counterpart goes too, as it would otherwise be loaded in its place.
+func removeEarlierResults(opts Options) error {
+	files := []string{journalFile(opts.Output)}
+	switch opts.Format {
+	case "ndjson":
+		files = []string{ndjsonResultsFile(opts.Output)}
+	case "csv":
+		files = append(files, csvResultsFile(opts.Output))
+	case "both":
+		files = append(files, opts.Output, compressionSibling(opts.Output), csvResultsFile(opts.Output))
+	default:
+		files = append(files, opts.Output, compressionSibling(opts.Output))
+	}
+
+	for _, file := range files {
+		if _, err := os.Stat(file); err != nil {
+			continue
+		}
+		if opts.DryRun {
+			slog.Warn("Clean run would remove earlier results", "file", file)
+			continue
+		}
+		if err := os.Remove(file); err != nil {
+			return fmt.Errorf("error removing earlier results: %v", err)
+		}
+		slog.Warn("Removed earlier results for a clean run", "file", file)
+	}
+	return nil
+}
+
+// fileRange returns the files from the one named start to the one named end inclusive,
+// matched by base name; an empty name leaves that end of the range open
+func fileRange(files []string, start, end string) ([]string, error) {
+	first, last := 0, len(files)-1
+	if start != "" {
+		first = slices.IndexFunc(files, func(file string) bool { return filepath.Base(file) == start })
+		if first < 0 {
+			return nil, fmt.Errorf("start file %s not found in postcode directory", start)
+		}
+	}
+	if end != "" {
+		last = slices.IndexFunc(files, func(file string) bool { return filepath.Base(file) == end })
+		if last < 0 {
+			return nil, fmt.Errorf("end file %s not found in postcode directory", end)
+		}
+	}
+	if first > last {
+		return nil, fmt.Errorf("start file %s sorts after end file %s", start, end)
+	}
+	return files[first : last+1], nil
+}
+
+// postcodeFiles lists the plain and gzipped CSV files in dir, sorted by name
+func postcodeFiles(dir string) ([]string, error) {
+	// Make sure the postcode directory exists before globbing it
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading postcode directory: %v", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("postcode directory %s is not a directory", dir)
+	}
+
+	// Get list of CSV files, plain or gzipped
+	var files []string
+	for _, pattern := range []string{"*.csv", "*.csv.gz"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("error reading postcode directory: %v", err)
+		}
+		files = append(files, matches...)
+	}
+
+	// Sort files to ensure consistent ordering
+	sort.Strings(files)
+	return files, nil
+}
@@ -0,0 +1,34 @@
+package supplier
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunRefusesLockedFiles(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{
+		Fetcher:             NewFetcher(),
+		Input:               strings.NewReader("SW1A 1AA\n"),
+		Output:              filepath.Join(dir, ResultsFile),
+		ProgressFile:        filepath.Join(dir, ProgressFile),
+		FailedPostcodesFile: filepath.Join(dir, FailedPostcodesFile),
+		DeadLetterFile:      filepath.Join(dir, DeadLetterFile),
+		ManifestFile:        filepath.Join(dir, ManifestFile),
+	}
+
+	// Another run sharing either the progress or the results file stops this one starting
+	for _, file := range []string{opts.ProgressFile, opts.Output} {
+		lock, err := lockFile(lockFilename(file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = Run(context.Background(), opts)
+		unlockFile(lock)
+		if err == nil || !strings.Contains(err.Error(), "already in progress") {
+			t.Errorf("Run() with %s locked error = %v, want already in progress", filepath.Base(file), err)
+		}
+	}
+}
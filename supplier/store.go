@@ -0,0 +1,210 @@
+package supplier
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite" // Registers the pure Go "sqlite" database/sql driver
+)
+
+// DatabaseFile is the default database the sqlite store keeps results in
+const DatabaseFile = "water_suppliers_results.db"
+
+// ResultStore persists postcode results into a SQLite database
+type ResultStore struct {
+	db *sql.DB
+}
+
+// Open opens (or creates) the SQLite database at path and ensures the schema exists
+func Open(path string) (*ResultStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening database: %v", err)
+	}
+
+	// SQLite only supports a single writer, so serialise access through one connection
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS results (
+		postcode TEXT PRIMARY KEY,
+		supplier TEXT NOT NULL,
+		phone    TEXT NOT NULL,
+		link     TEXT NOT NULL
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating results table: %v", err)
+	}
+
+	if err := addMissingColumns(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &ResultStore{db: db}, nil
+}
+
+// addedColumns lists columns introduced after the original schema, with their SQL definitions
+var addedColumns = []struct {
+	name       string
+	definition string
+}{
+	{"sewerage_supplier", "TEXT NOT NULL DEFAULT ''"},
+	{"sewerage_phone", "TEXT NOT NULL DEFAULT ''"},
+	{"sewerage_link", "TEXT NOT NULL DEFAULT ''"},
+	{"fetched_at", "TEXT NOT NULL DEFAULT ''"},
+	{"email", "TEXT NOT NULL DEFAULT ''"},
+	{"sewerage_email", "TEXT NOT NULL DEFAULT ''"},
+	{"address", "TEXT NOT NULL DEFAULT ''"},
+	{"sewerage_address", "TEXT NOT NULL DEFAULT ''"},
+	{"link_title", "TEXT NOT NULL DEFAULT ''"},
+	{"approximated_from", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// addMissingColumns migrates databases created by older versions by adding any new columns
+func addMissingColumns(db *sql.DB) error {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info('results')`)
+	if err != nil {
+		return fmt.Errorf("error reading results table columns: %v", err)
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return fmt.Errorf("error scanning column name: %v", err)
+		}
+		existing[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading results table columns: %v", err)
+	}
+
+	for _, column := range addedColumns {
+		if existing[column.name] {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf(`ALTER TABLE results ADD COLUMN %s %s`, column.name, column.definition)); err != nil {
+			return fmt.Errorf("error adding column %s: %v", column.name, err)
+		}
+	}
+
+	return nil
+}
+
+// Insert upserts results in a single transaction, replacing any existing rows for their
+// postcodes
+func (s *ResultStore) Insert(results ...PostcodeResult) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT INTO results (postcode, supplier, phone, link,
+			sewerage_supplier, sewerage_phone, sewerage_link, fetched_at, email, sewerage_email,
+			address, sewerage_address, link_title, approximated_from)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(postcode) DO UPDATE SET
+			supplier          = excluded.supplier,
+			phone             = excluded.phone,
+			link              = excluded.link,
+			sewerage_supplier = excluded.sewerage_supplier,
+			sewerage_phone    = excluded.sewerage_phone,
+			sewerage_link     = excluded.sewerage_link,
+			fetched_at        = excluded.fetched_at,
+			email             = excluded.email,
+			sewerage_email    = excluded.sewerage_email,
+			address           = excluded.address,
+			sewerage_address  = excluded.sewerage_address,
+			link_title        = excluded.link_title,
+			approximated_from = excluded.approximated_from`)
+	if err != nil {
+		return fmt.Errorf("error preparing insert: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, result := range results {
+		_, err := stmt.Exec(result.Postcode, result.Supplier, result.Phone, result.Link,
+			result.SewerageSupplier, result.SeweragePhone, result.SewerageLink,
+			formatFetchedAt(result.FetchedAt), result.Email, result.SewerageEmail,
+			result.Address, result.SewerageAddress, result.LinkTitle, result.ApproximatedFrom)
+		if err != nil {
+			return fmt.Errorf("error inserting result for postcode %s: %v", result.Postcode, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing results: %v", err)
+	}
+	return nil
+}
+
+// Clear deletes every stored result
+func (s *ResultStore) Clear() error {
+	if _, err := s.db.Exec(`DELETE FROM results`); err != nil {
+		return fmt.Errorf("error clearing results: %v", err)
+	}
+	return nil
+}
+
+// FetchTimes returns every postcode already stored in the database with when its result
+// was fetched, zero for results stored before timestamps were recorded
+func (s *ResultStore) FetchTimes() (map[string]time.Time, error) {
+	rows, err := s.db.Query(`SELECT postcode, fetched_at FROM results`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying postcodes: %v", err)
+	}
+	defer rows.Close()
+
+	fetchTimes := make(map[string]time.Time)
+	for rows.Next() {
+		var postcode, fetchedAt string
+		if err := rows.Scan(&postcode, &fetchedAt); err != nil {
+			return nil, fmt.Errorf("error scanning postcode: %v", err)
+		}
+		// Unparseable timestamps are left zero, so the result counts as stale
+		fetchTimes[postcode], _ = time.Parse(time.RFC3339, fetchedAt)
+	}
+	return fetchTimes, rows.Err()
+}
+
+// AllResults returns every stored result ordered by postcode
+func (s *ResultStore) AllResults() ([]PostcodeResult, error) {
+	rows, err := s.db.Query(`SELECT postcode, supplier, phone, link,
+		sewerage_supplier, sewerage_phone, sewerage_link, fetched_at, email, sewerage_email,
+		address, sewerage_address, link_title, approximated_from
+		FROM results ORDER BY postcode`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying results: %v", err)
+	}
+	defer rows.Close()
+
+	var results []PostcodeResult
+	for rows.Next() {
+		var result PostcodeResult
+		var fetchedAt string
+		err := rows.Scan(&result.Postcode, &result.Supplier, &result.Phone, &result.Link,
+			&result.SewerageSupplier, &result.SeweragePhone, &result.SewerageLink, &fetchedAt,
+			&result.Email, &result.SewerageEmail, &result.Address, &result.SewerageAddress,
+			&result.LinkTitle, &result.ApproximatedFrom)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning result: %v", err)
+		}
+		if fetchedAt != "" {
+			result.FetchedAt, err = time.Parse(time.RFC3339, fetchedAt)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing fetched_at for postcode %s: %v", result.Postcode, err)
+			}
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// Close closes the underlying database
+func (s *ResultStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,47 @@
+package supplier
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStoreUpsertsResults(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "results.db"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer store.Close()
+
+	want := testResults()
+	for i := range want {
+		// The store keeps no status column
+		want[i].Status = ""
+	}
+	stale := want[0]
+	stale.Supplier = "Thames Water"
+	if err := store.Insert(stale, want[1]); err != nil {
+		t.Fatalf("Insert() error = %v", err)
+	}
+	if err := store.Insert(want[0]); err != nil {
+		t.Fatalf("Insert() error = %v", err)
+	}
+
+	got, err := store.AllResults()
+	if err != nil {
+		t.Fatalf("AllResults() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("AllResults() =\n%+v\nwant\n%+v", got, want)
+	}
+
+	fetchTimes, err := store.FetchTimes()
+	if err != nil {
+		t.Fatalf("FetchTimes() error = %v", err)
+	}
+	for _, result := range want {
+		if !fetchTimes[result.Postcode].Equal(result.FetchedAt) {
+			t.Errorf("FetchTimes()[%q] = %v, want %v", result.Postcode, fetchTimes[result.Postcode], result.FetchedAt)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package supplier
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// RunSummary accumulates counters describing a single run
+type RunSummary struct {
+	Version    string    `json:"version,omitempty"` // Build that produced the run, set by the caller
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+
+	Processed    int   `json:"processed"`     // Postcodes looked up
+	Found        int   `json:"found"`         // Lookups that returned a supplier
+	NotFound     int   `json:"not_found"`     // Lookups answered without a supplier
+	Errored      int   `json:"errored"`       // Lookups that failed outright
+	Approximated int   `json:"approximated"`  // Results copied from another postcode in the outward code
+	Skipped      int   `json:"skipped"`       // Postcodes skipped as already processed
+	SkipListed   int   `json:"skip_listed"`   // Postcodes skipped as in the skip list
+	NotListed    int   `json:"not_listed"`    // Postcodes skipped as not in the allowlist
+	DeadLettered int   `json:"dead_lettered"` // Postcodes dead-lettered by a retry pass or skipped as already dead-lettered
+	Requests     int64 `json:"requests"`      // HTTP requests issued, including retries
+
+	DurationSeconds   float64 `json:"duration_seconds"`
+	RequestsPerSecond float64 `json:"requests_per_second"`
+}
+
+// record counts the outcome of a single lookup
+func (s *RunSummary) record(result PostcodeResult) {
+	s.Processed++
+	if result.ApproximatedFrom != "" {
+		s.Approximated++
+	}
+	switch result.Status {
+	case StatusFound:
+		s.Found++
+	case StatusNotFound:
+		s.NotFound++
+	default:
+		s.Errored++
+	}
+}
+
+// finish stamps the end time and derives the duration and request rate
+func (s *RunSummary) finish(requests int64) {
+	s.FinishedAt = time.Now()
+	s.Requests = requests
+
+	duration := s.FinishedAt.Sub(s.StartedAt)
+	s.DurationSeconds = duration.Seconds()
+	if s.DurationSeconds > 0 {
+		s.RequestsPerSecond = float64(s.Requests) / s.DurationSeconds
+	}
+}
+
+// Print writes a human-readable summary block
+func (s *RunSummary) Print(w io.Writer) {
+	duration := s.FinishedAt.Sub(s.StartedAt).Round(time.Second)
+	fmt.Fprintln(w, "Run summary")
+	if s.Version != "" {
+		fmt.Fprintf(w, "  Version:      %s\n", s.Version)
+	}
+	fmt.Fprintf(w, "  Processed:    %d\n", s.Processed)
+	fmt.Fprintf(w, "  Found:        %d\n", s.Found)
+	fmt.Fprintf(w, "  Not found:    %d\n", s.NotFound)
+	fmt.Fprintf(w, "  Errored:      %d\n", s.Errored)
+	fmt.Fprintf(w, "  Approximated: %d\n", s.Approximated)
+	fmt.Fprintf(w, "  Skipped:      %d\n", s.Skipped)
+	fmt.Fprintf(w, "  Skip listed:  %d\n", s.SkipListed)
+	fmt.Fprintf(w, "  Not listed:   %d\n", s.NotListed)
+	fmt.Fprintf(w, "  Dead letter:  %d\n", s.DeadLettered)
+	fmt.Fprintf(w, "  Requests:     %d\n", s.Requests)
+	fmt.Fprintf(w, "  Duration:     %s\n", duration)
+	fmt.Fprintf(w, "  Requests/sec: %.2f\n", s.RequestsPerSecond)
+}
+
+// Save writes the summary as JSON to filename
+func (s *RunSummary) Save(filename string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling summary: %v", err)
+	}
+
+	err = writeFileAtomic(filename, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error writing summary file: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package supplier
+
+import (
+	"time"
+)
+
+// resultWriter owns the results output on a goroutine of its own, so collecting results
+// never waits on disk. Results sent to it are batched and written every saveEvery results
+// or saveInterval, whichever comes first.
+type resultWriter struct {
+	write   func([]PostcodeResult) error // Writes a batch through to disk
+	onError func(error)                  // Called from the writer goroutine when a write fails
+
+	results chan queuedResult
+	flushes chan chan error
+	done    chan struct{}
+}
+
+// queuedResult is a result waiting to be written, with the function to call once it is
+type queuedResult struct {
+	result  PostcodeResult
+	written func()
+}
+
+// startResultWriter starts a writer saving batches with write, reporting failures to onError
+func startResultWriter(write func([]PostcodeResult) error, onError func(error)) *resultWriter {
+	w := &resultWriter{
+		write:   write,
+		onError: onError,
+		results: make(chan queuedResult, saveEvery),
+		flushes: make(chan chan error),
+		done:    make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// run batches results until the results channel is closed, writing whatever is left
+func (w *resultWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(saveInterval)
+	defer ticker.Stop()
+
+	var batch []queuedResult
+	var err error // Once a write fails nothing more is written, so later batches can't reorder
+	flush := func() error {
+		if len(batch) == 0 || err != nil {
+			return err
+		}
+		results := make([]PostcodeResult, len(batch))
+		for i, queued := range batch {
+			results[i] = queued.result
+		}
+		if err = w.write(results); err != nil {
+			w.onError(err)
+			return err
+		}
+		for _, queued := range batch {
+			if queued.written != nil {
+				queued.written()
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		select {
+		case result, ok := <-w.results:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, result)
+			if len(batch) >= saveEvery {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case reply := <-w.flushes:
+			// Pick up results already queued, so everything written before Flush is saved
+			for queued := true; queued; {
+				select {
+				case result, ok := <-w.results:
+					if ok {
+						batch = append(batch, result)
+					}
+					queued = ok
+				default:
+					queued = false
+				}
+			}
+			reply <- flush()
+		}
+	}
+}
+
+// Write queues result to be written, calling written from the writer goroutine once it is
+// safely on disk; written is never called if the write fails. It may be nil.
+func (w *resultWriter) Write(result PostcodeResult, written func()) {
+	w.results <- queuedResult{result: result, written: written}
+}
+
+// Flush writes every queued result through to disk, returning the first write error
+func (w *resultWriter) Flush() error {
+	reply := make(chan error)
+	w.flushes <- reply
+	return <-reply
+}
+
+// Close writes any queued results and stops the writer
+func (w *resultWriter) Close() {
+	close(w.results)
+	<-w.done
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// Build information, injected at build time with
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.commit=$(git rev-parse HEAD) -X main.date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
+
+// buildInfo describes the running build, falling back to the VCS details the Go toolchain
+// embeds when the ldflags weren't set
+func buildInfo() string {
+	rev, built := commit, date
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && rev == "":
+				rev = setting.Value
+			case setting.Key == "vcs.time" && built == "":
+				built = setting.Value
+			}
+		}
+	}
+	if rev == "" {
+		rev = "unknown"
+	}
+	if built == "" {
+		built = "unknown"
+	}
+	return fmt.Sprintf("%s (commit %s, built %s)", version, rev, built)
+}
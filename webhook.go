@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/MaxWCode/TappedIN/supplier"
+)
+
+const (
+	// webhookTimeout bounds each delivery attempt to a webhook
+	webhookTimeout = 5 * time.Second
+
+	// webhookAttempts is how many times a delivery is tried before it's given up on
+	webhookAttempts = 3
+
+	// webhookQueueSize is how many results may wait for delivery before new ones are
+	// dropped, so a slow webhook never holds up the run
+	webhookQueueSize = 1000
+)
+
+// resultWebhook POSTs each result as JSON to a URL from a goroutine of its own, so
+// deliveries don't slow down lookups. Failed deliveries are logged and dropped.
+type resultWebhook struct {
+	url     string
+	client  *http.Client
+	results chan supplier.PostcodeResult
+	wg      sync.WaitGroup
+}
+
+// startResultWebhook starts delivering results sent to Send to url
+func startResultWebhook(url string) *resultWebhook {
+	w := &resultWebhook{
+		url:     url,
+		client:  &http.Client{Timeout: webhookTimeout},
+		results: make(chan supplier.PostcodeResult, webhookQueueSize),
+	}
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		for result := range w.results {
+			if err := w.deliver(result); err != nil {
+				slog.Warn("Error delivering result to webhook", "postcode", result.Postcode, "err", err)
+			}
+		}
+	}()
+	return w
+}
+
+// Send queues result for delivery, dropping it if the webhook has fallen too far behind
+func (w *resultWebhook) Send(result supplier.PostcodeResult) {
+	select {
+	case w.results <- result:
+	default:
+		slog.Warn("Webhook falling behind, dropping result", "postcode", result.Postcode)
+	}
+}
+
+// Close delivers the results still queued and stops the webhook
+func (w *resultWebhook) Close() {
+	close(w.results)
+	w.wg.Wait()
+}
+
+// deliver POSTs result, retrying failed attempts after a short pause
+func (w *resultWebhook) deliver(result supplier.PostcodeResult) error {
+	body, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("error marshalling result: %v", err)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = postJSON(context.Background(), w.client, w.url, body)
+		if err == nil || attempt == webhookAttempts {
+			return err
+		}
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
+
+// postJSON POSTs body to url as JSON, treating any non-2xx status as an error
+func postJSON(ctx context.Context, client *http.Client, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("received non-OK HTTP status: %s", resp.Status)
+	}
+	return nil
+}